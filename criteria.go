@@ -0,0 +1,73 @@
+package stdmodel
+
+import (
+	"strings"
+
+	"github.com/uptrace/bun"
+)
+
+// Criteria builds filters at runtime and can be passed as args anywhere a
+// filter struct is accepted. Conditions are combined with AND.
+type Criteria struct {
+	conds []criterion
+}
+
+type criterion struct {
+	column string
+	op     string
+	value  any
+}
+
+func NewCriteria() *Criteria {
+	return &Criteria{}
+}
+
+func (c *Criteria) Eq(column string, value any) *Criteria {
+	return c.add(column, "=", value)
+}
+
+func (c *Criteria) Gt(column string, value any) *Criteria {
+	return c.add(column, ">", value)
+}
+
+func (c *Criteria) Gte(column string, value any) *Criteria {
+	return c.add(column, ">=", value)
+}
+
+// In matches column against any of values, which must be a slice.
+func (c *Criteria) In(column string, values any) *Criteria {
+	return c.add(column, "IN", bun.In(values))
+}
+
+func (c *Criteria) Lt(column string, value any) *Criteria {
+	return c.add(column, "<", value)
+}
+
+func (c *Criteria) Lte(column string, value any) *Criteria {
+	return c.add(column, "<=", value)
+}
+
+func (c *Criteria) Ne(column string, value any) *Criteria {
+	return c.add(column, "<>", value)
+}
+
+func (c *Criteria) add(column, op string, value any) *Criteria {
+	c.conds = append(c.conds, criterion{column: column, op: op, value: value})
+	return c
+}
+
+func (c *Criteria) apply(q *bun.SelectQuery) {
+	for _, cc := range c.conds {
+		column := "?TableAlias.?"
+
+		if strings.Contains(cc.column, ".") {
+			column = "?"
+		}
+
+		if cc.op == "IN" {
+			q.Where(column+" IN (?)", bun.Ident(cc.column), cc.value)
+		} else {
+			q.Where(column+" "+cc.op+" ?", bun.Ident(cc.column), cc.value)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package stdmodel
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uptrace/bun"
+)
+
+type Option func(*options)
+
+type EmptyUpdate int
+
+const (
+	// EmptyUpdateAll updates every column on conflict.
+	EmptyUpdateAll EmptyUpdate = iota
+	// EmptyUpdateNothing leaves the existing row untouched on conflict.
+	EmptyUpdateNothing
+	// EmptyUpdateError fails with ErrNoUpdateColumns.
+	EmptyUpdateError
+)
+
+type options struct {
+	allRelations     bool
+	analyze          bool
+	audit            func(context.Context) (any, any, bool)
+	clock            func() time.Time
+	columns          []string
+	conflictColumn   string
+	conflictOn       string
+	conflictPartial  string
+	conflictUpdate   []string
+	conflictWhere    []where
+	crypters         []crypter
+	defaultOrder     string
+	emptyUpdate      EmptyUpdate
+	excludeColumns   []string
+	filterTag        string
+	forceZero        []string
+	ignoreConflict   bool
+	indexHint        string
+	noDefaults       bool
+	nowExpression    bool
+	onlyTrashed      bool
+	order            string
+	queryComment     bool
+	rank             []where
+	relations        []relation
+	retries          int
+	returning        []string
+	scanError        func(error)
+	schema           string
+	scopes           []string
+	skipInvalidEnums bool
+	skipLocked       bool
+	softDeleteAlive  string
+	softDeleteColumn string
+	softDeleteValue  any
+	strictFilters    bool
+	table            string
+	uniqueKeys       bool
+	updatedColumn    string
+	wheres           []where
+	withTrashed      bool
+}
+
+type crypter struct {
+	columns []string
+	encrypt func([]byte) ([]byte, error)
+	decrypt func([]byte) ([]byte, error)
+}
+
+type relation struct {
+	name  string
+	apply func(*bun.SelectQuery) *bun.SelectQuery
+}
+
+type where struct {
+	query    string
+	args     []any
+	or       bool
+	err      error
+	postgres string
+}
+
+func (w where) check(d Dialect) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.postgres != "" && d != DialectPostgres {
+		return errors.Errorf("%s requires postgres", w.postgres)
+	}
+
+	return nil
+}
+
+func extend[T any](s []T, vs ...T) []T {
+	return append(s[:len(s):len(s)], vs...)
+}
+
+func (o options) now() time.Time {
+	if o.clock != nil {
+		return o.clock()
+	}
+
+	return time.Now()
+}
+
+// crypter returns the WithColumnCrypter configured for column, if any.
+func (o options) crypter(column string) (crypter, bool) {
+	for _, c := range o.crypters {
+		for _, cc := range c.columns {
+			if cc == column {
+				return c, true
+			}
+		}
+	}
+
+	return crypter{}, false
+}
+
+func (m *Models) options(opts []Option) options {
+	o := m.opts
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// AllRelations makes Get load every relation declared on the model. Related
+// rows are loaded by bun directly, so their own query defaults, such as soft
+// delete filtering, are not applied.
+func AllRelations() Option {
+	return func(o *options) {
+		o.allRelations = true
+	}
+}
+
+// Analyze makes ExplainPlan use EXPLAIN ANALYZE on Postgres, which executes
+// the query.
+func Analyze() Option {
+	return func(o *options) {
+		o.analyze = true
+	}
+}
+
+// Columns restricts reads to the given columns, leaving the other fields of
+// the model zero.
+func Columns(columns ...string) Option {
+	return func(o *options) {
+		o.columns = extend(o.columns, columns...)
+	}
+}
+
+// ConflictOn makes upserts target the named bun unique group, as declared with
+// unique:"group" tags, or a single column tagged unique, instead of the
+// primary key.
+func ConflictOn(group string) Option {
+	return func(o *options) {
+		o.conflictOn = group
+	}
+}
+
+// ConflictTarget makes upserts target column where predicate holds, such as
+// "WHERE deleted_at IS NULL", matching a partial unique index. It takes
+// precedence over ConflictOn. Not supported on MySQL.
+func ConflictTarget(column, predicate string) Option {
+	return func(o *options) {
+		o.conflictColumn = column
+		o.conflictPartial = predicate
+	}
+}
+
+// ConflictUpdateWhere only lets an upsert update the existing row when the
+// condition holds, such as "EXCLUDED.updated_at > ?TableAlias.updated_at".
+// Not supported on MySQL.
+func ConflictUpdateWhere(query string, args ...any) Option {
+	return func(o *options) {
+		o.conflictWhere = extend(o.conflictWhere, where{query: query, args: args})
+	}
+}
+
+// ExcludeColumns leaves the given columns out of INSERT statements so values
+// maintained by the database are never overwritten.
+func ExcludeColumns(columns ...string) Option {
+	return func(o *options) {
+		o.excludeColumns = extend(o.excludeColumns, columns...)
+	}
+}
+
+// ForceZero makes Create, Save and Upsert write the given columns even when
+// their value is zero, rather than NULL or the column default.
+func ForceZero(columns ...string) Option {
+	return func(o *options) {
+		o.forceZero = extend(o.forceZero, columns...)
+	}
+}
+
+// FullText adds a Where condition matching the tsvector column against the
+// plain text query, as in column @@ plainto_tsquery(query). Postgres only.
+func FullText(column, query string) Option {
+	return func(o *options) {
+		o.wheres = extend(o.wheres, where{query: "?TableAlias.? @@ plainto_tsquery(?)", args: []any{bun.Ident(column), query}, postgres: "full-text search"})
+	}
+}
+
+// FullTextRank orders Find and List by the ts_rank of the tsvector column
+// against the plain text query, best matches first, ahead of any other order.
+// Postgres only.
+func FullTextRank(column, query string) Option {
+	return func(o *options) {
+		o.rank = extend(o.rank, where{query: "ts_rank(?TableAlias.?, plainto_tsquery(?)) DESC", args: []any{bun.Ident(column), query}, postgres: "full-text ranking"})
+	}
+}
+
+// IgnoreConflict makes Create skip rows that conflict with an existing one
+// instead of failing. A skipped row is not an error but its generated columns,
+// such as the primary key, are left unset.
+func IgnoreConflict() Option {
+	return func(o *options) {
+		o.ignoreConflict = true
+	}
+}
+
+// IndexHint adds a MySQL index hint after the table of reads, such as "FORCE
+// INDEX (idx_email)", "USE INDEX (a, b)" or "IGNORE INDEX (c)". Other dialects
+// ignore it.
+func IndexHint(hint string) Option {
+	return func(o *options) {
+		o.indexHint = hint
+	}
+}
+
+// NoDefaults skips every read default for a single call: soft delete
+// filtering, QueryDefaulter, ContextQueryDefaulter, ContextFilterer and
+// WithDefaultOrder. As those defaults often scope rows to a tenant or user,
+// only use it for trusted maintenance code.
+func NoDefaults() Option {
+	return func(o *options) {
+		o.noDefaults = true
+	}
+}
+
+// OnConflictUpdate adds columns to update when an upsert hits a conflict, in
+// addition to those tagged model:"update".
+func OnConflictUpdate(columns ...string) Option {
+	return func(o *options) {
+		o.conflictUpdate = extend(o.conflictUpdate, columns...)
+	}
+}
+
+// OnlyTrashed restricts a single call to soft-deleted rows. Only the soft
+// delete condition changes, the model's other defaults still apply. The query
+// errors with ErrNoSoftDelete if the model has no soft delete column.
+func OnlyTrashed() Option {
+	return func(o *options) {
+		o.onlyTrashed = true
+	}
+}
+
+// OrderBy sets the order of a single Find or List call, such as "name DESC" or
+// "updated_at DESC NULLS LAST", overriding order tags on filter fields and
+// WithDefaultOrder. NULLS FIRST and NULLS LAST are emulated on MySQL.
+func OrderBy(order string) Option {
+	return func(o *options) {
+		o.order = order
+	}
+}
+
+// Relation loads the named bun relations, such as "Author", along with the
+// rows read by Find and List.
+func Relation(names ...string) Option {
+	return func(o *options) {
+		for _, name := range names {
+			o.relations = extend(o.relations, relation{name: name})
+		}
+	}
+}
+
+// RelationFn is Relation for a single relation whose query is customized by
+// apply, for example to order or filter the rows of a has-many relation.
+func RelationFn(name string, apply func(*bun.SelectQuery) *bun.SelectQuery) Option {
+	return func(o *options) {
+		o.relations = extend(o.relations, relation{name: name, apply: apply})
+	}
+}
+
+// Returning makes Create scan the given columns back into the model, such as
+// ones filled in by database defaults. The primary key and columns bun would
+// return itself, such as autoincrement ones, are still returned. Not supported
+// on MySQL.
+func Returning(columns ...string) Option {
+	return func(o *options) {
+		o.returning = extend(o.returning, columns...)
+	}
+}
+
+// Schema qualifies the table of each statement with schema, such as a
+// per-tenant Postgres schema. Unlike SET search_path it does not depend on the
+// connection, so it behaves the same inside and outside transactions. Tables
+// of joined relations are not qualified.
+func Schema(schema string) Option {
+	return func(o *options) {
+		o.schema = schema
+	}
+}
+
+// Scope applies the named scopes of a model implementing Scoper to reads,
+// failing on names the model does not declare.
+func Scope(names ...string) Option {
+	return func(o *options) {
+		o.scopes = extend(o.scopes, names...)
+	}
+}
+
+// SkipInvalidEnums leaves filters whose EnumValidator value is invalid out of
+// the query instead of failing with ErrInvalidEnum.
+func SkipInvalidEnums() Option {
+	return func(o *options) {
+		o.skipInvalidEnums = true
+	}
+}
+
+// SkipLocked makes locking reads skip rows already locked by another
+// transaction instead of waiting for them, which suits queue-style workloads.
+func SkipLocked() Option {
+	return func(o *options) {
+		o.skipLocked = true
+	}
+}
+
+// SkipScanErrors makes List pass rows that fail to scan to fn and carry on
+// with the rest instead of failing.
+func SkipScanErrors(fn func(err error)) Option {
+	return func(o *options) {
+		o.scanError = fn
+	}
+}
+
+// Table overrides the table a model maps to, for example to target the same
+// table in another schema with "analytics.events".
+func Table(table string) Option {
+	return func(o *options) {
+		o.table = table
+	}
+}
+
+// UniqueKeys makes ListMap fail with ErrDuplicateKey when two rows map to the
+// same key.
+func UniqueKeys() Option {
+	return func(o *options) {
+		o.uniqueKeys = true
+	}
+}
+
+// Where adds a condition to reads, such as Where("status = ?", "active").
+// Where and WhereOr conditions form one parenthesized group, joined in the
+// order given, that is ANDed with filters and query defaults.
+func Where(query string, args ...any) Option {
+	return func(o *options) {
+		o.wheres = extend(o.wheres, where{query: query, args: args})
+	}
+}
+
+var columnOps = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// WhereColumns adds a Where condition comparing two columns of the same row,
+// such as WhereColumns("start_date", "<=", "end_date").
+func WhereColumns(left, op, right string) Option {
+	if !columnOps[op] {
+		return func(o *options) {
+			o.wheres = extend(o.wheres, where{err: errors.Errorf("invalid operator: %q", op)})
+		}
+	}
+
+	return Where("? "+op+" ?", bun.Ident(left), bun.Ident(right))
+}
+
+// WhereInSubquery adds a Where condition matching column against the rows of
+// sub, which can itself come from Select.
+func WhereInSubquery(column string, sub *bun.SelectQuery) Option {
+	return Where("? IN (?)", bun.Ident(column), sub)
+}
+
+// WhereOr is like Where but joins its condition to the preceding ones with OR.
+func WhereOr(query string, args ...any) Option {
+	return func(o *options) {
+		o.wheres = extend(o.wheres, where{query: query, args: args, or: true})
+	}
+}
+
+// WithAuditColumns fills the created_by and updated_by columns of models that
+// have them from fn, typically with the user found in ctx. Create, Upsert and
+// Save fill both, Update only updated_by. Nothing is filled when fn returns
+// false.
+func WithAuditColumns(fn func(ctx context.Context) (createdBy, updatedBy any, ok bool)) Option {
+	return func(o *options) {
+		o.audit = fn
+	}
+}
+
+// WithAutoRetry retries Count, Exists, Find, Get and List up to n times, with
+// a short backoff, when they fail with driver.ErrBadConn or sql.ErrConnDone.
+// Writes are never retried, as a failed write may still have been applied.
+func WithAutoRetry(n int) Option {
+	return func(o *options) {
+		o.retries = n
+	}
+}
+
+// WithClock sets the source of the current time used by Touch, Purge and soft
+// deletes, such as a frozen clock in tests. Columns soft deleted natively by
+// bun still use the time bun picks. Defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.clock = now
+	}
+}
+
+// WithColumnCrypter stores the given string or []byte columns encrypted with
+// enc. Writes encrypt them before writing, including the values passed to
+// UpdateColumns and UpdateWhere, leaving the model itself in plaintext, and
+// reads decrypt them with dec after scanning models, plucked values or
+// CountBy keys. Scalar, SelectInto, related rows and filters see the
+// ciphertext. Ciphertext for string columns should be text safe, such as
+// base64.
+func WithColumnCrypter(columns []string, enc, dec func([]byte) ([]byte, error)) Option {
+	return func(o *options) {
+		o.crypters = extend(o.crypters, crypter{columns: columns, encrypt: enc, decrypt: dec})
+	}
+}
+
+// WithDefaultOrder sets the order Find and List use when neither OrderBy nor
+// order tags on filter fields give one, such as "id ASC", so results are
+// deterministic.
+func WithDefaultOrder(order string) Option {
+	return func(o *options) {
+		o.defaultOrder = order
+	}
+}
+
+// WithEmptyUpdate sets how Save and Upsert behave when no update columns are
+// known. Models with nothing but primary key columns always do nothing.
+func WithEmptyUpdate(mode EmptyUpdate) Option {
+	return func(o *options) {
+		o.emptyUpdate = mode
+	}
+}
+
+// WithFilterTag sets the struct tag used to map filter fields to columns.
+// Tag options such as json:"name,omitempty" are ignored. Defaults to "field".
+func WithFilterTag(tag string) Option {
+	return func(o *options) {
+		o.filterTag = tag
+	}
+}
+
+// WithNowExpression makes Touch and soft deletes write the database's current
+// time, now() on Postgres and CURRENT_TIMESTAMP elsewhere, instead of the time
+// from WithClock, avoiding skew between app servers. The model is given the
+// written time where the dialect supports RETURNING and left unchanged
+// otherwise. Purge still compares against WithClock.
+func WithNowExpression() Option {
+	return func(o *options) {
+		o.nowExpression = true
+	}
+}
+
+// WithQueryComment prefixes the table of each statement with a comment naming
+// the operation and model, such as /* op=List model=users */, so statements
+// can be traced in pg_stat_activity and database logs.
+func WithQueryComment() Option {
+	return func(o *options) {
+		o.queryComment = true
+	}
+}
+
+// WithSoftDelete is WithSoftDeleteColumn for schemas with their own
+// convention: deletes set column to deletedValue and reads only return rows
+// matching alivePredicate, such as "?TableAlias.deleted_at = '9999-12-31'". A
+// nil deletedValue keeps the default of true or the current time, and an
+// empty alivePredicate the default of false or IS NULL.
+func WithSoftDelete(column string, deletedValue any, alivePredicate string) Option {
+	return func(o *options) {
+		o.softDeleteColumn = column
+		o.softDeleteValue = deletedValue
+		o.softDeleteAlive = alivePredicate
+	}
+}
+
+// WithSoftDeleteColumn declares the column marking soft-deleted rows for any
+// model that has it; reads then hide those rows. Models implementing
+// SoftDeleter take precedence.
+func WithSoftDeleteColumn(column string) Option {
+	return func(o *options) {
+		o.softDeleteColumn = column
+		o.softDeleteValue = nil
+		o.softDeleteAlive = ""
+	}
+}
+
+// WithStrictFilters validates filter columns against the model before querying
+// and fails with ErrUnknownColumn on any the model does not have.
+func WithStrictFilters() Option {
+	return func(o *options) {
+		o.strictFilters = true
+	}
+}
+
+// WithTrashed skips the model's query defaults for a single call so rows they
+// would normally hide, such as soft-deleted ones, are included. ContextFilterer
+// filters still apply.
+func WithTrashed() Option {
+	return func(o *options) {
+		o.withTrashed = true
+	}
+}
+
+// WithUpdatedColumn sets the column Touch writes for models without a field
+// tagged model:"updated".
+func WithUpdatedColumn(column string) Option {
+	return func(o *options) {
+		o.updatedColumn = column
+	}
+}
+
+// WithinDistance adds a Where condition matching rows whose geography column
+// lies within meters of the point at lat and lng, using PostGIS ST_DWithin.
+// Postgres only.
+func WithinDistance(column string, lat, lng, meters float64) Option {
+	return func(o *options) {
+		o.wheres = extend(o.wheres, where{query: "ST_DWithin(?TableAlias.?, ST_MakePoint(?, ?)::geography, ?)", args: []any{bun.Ident(column), lng, lat, meters}, postgres: "distance filters"})
+	}
+}
@@ -2,240 +2,3429 @@ package stdmodel
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/feature"
 	"github.com/uptrace/bun/schema"
 )
 
+var (
+	ErrDuplicateKey       = errors.New("duplicate key")
+	ErrInvalidEnum        = errors.New("invalid enum value")
+	ErrModelNotRegistered = errors.New("model not registered")
+	ErrMultipleResults    = errors.New("multiple results")
+	ErrNoFilters          = errors.New("no filters")
+	ErrNoSoftDelete       = errors.New("no soft delete column")
+	ErrNoUpdateColumns    = errors.New("no update columns")
+	ErrNoUpdatedColumn    = errors.New("no updated column")
+	ErrNotFound           = errors.New("not found")
+	ErrNotSlicePointer    = errors.New("pointer to slice expected")
+	ErrUnknownColumn      = errors.New("unknown column")
+)
+
 type Models struct {
-	db *bun.DB
+	conn    bun.IDB
+	db      *bun.DB
+	dialect Dialect
+	opts    options
+}
+
+// Dialect identifies the database Models runs against.
+type Dialect int
+
+const (
+	DialectUnknown Dialect = iota
+	DialectPostgres
+	DialectMySQL
+	DialectSQLite
+)
+
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// SaveResult reports what SaveManyResult did with a row.
+type SaveResult int
+
+const (
+	SaveInserted SaveResult = iota
+	SaveUpdated
+)
+
+type allRows struct{}
+
+// All can be passed as args to methods that refuse to run without filters,
+// such as SoftDeleteWhere, to explicitly target every row.
+var All any = allRows{}
+
+// Filterable lets filter field types, such as nullable enum or UUID wrappers,
+// leave themselves out of the query when OmitFromQuery returns true. It is not
+// called on nil pointers.
+type Filterable interface {
+	OmitFromQuery() bool
+}
+
+// ContextFilterer is a structured ContextQueryDefaulter: reads of a model
+// implementing it are filtered by the clauses it derives from ctx, such as a
+// tenant ID. The filters still apply with WithTrashed and OnlyTrashed and are
+// only skipped by NoDefaults.
+type ContextFilterer interface {
+	ContextFilters(context.Context) []FilterClause
 }
 
+// FilterClause is a filter on Column, compared to Value as a filter field
+// tagged with Op would be: "" for equality, "like", "ilike" or "nulleq". Any
+// other Op fails the query.
+type FilterClause struct {
+	Column string
+	Op     string
+	Value  any
+}
+
+// EnumValidator lets enum filter field types reject values outside their
+// allowed set. Filters with an invalid value fail with ErrInvalidEnum, or are
+// left out of the query with SkipInvalidEnums.
+type EnumValidator interface {
+	Valid() bool
+}
+
+// InsertCustomizer lets a model adjust the INSERT statements of Create, Save
+// and Upsert, for example excluding columns generated by the database and
+// returning them instead. It runs after the query is otherwise built.
+type InsertCustomizer interface {
+	CustomizeInsert(*bun.InsertQuery) *bun.InsertQuery
+}
+
+// QueryDefaulter adds default conditions to every read of a model. Columns
+// should be qualified with ?TableAlias, as in q.Where("?TableAlias.deleted =
+// false"), so they stay unambiguous when relations are joined.
 type QueryDefaulter interface {
 	QueryDefault(*bun.SelectQuery) *bun.SelectQuery
 }
 
-func New(db *bun.DB) (*Models, error) {
+// ContextQueryDefaulter is a context-aware QueryDefaulter for defaults that
+// depend on request-scoped values. Subqueries should be built from q.DB() so
+// they run against the same database, for example:
+//
+//	func (u *User) QueryDefaultContext(ctx context.Context, q *bun.SelectQuery) *bun.SelectQuery {
+//		tenants := q.DB().NewSelect().Model((*Tenant)(nil)).Column("id").Where("org_id = ?", orgID(ctx))
+//		return q.Where("tenant_id IN (?)", tenants)
+//	}
+type ContextQueryDefaulter interface {
+	QueryDefaultContext(context.Context, *bun.SelectQuery) *bun.SelectQuery
+}
+
+// Scoper declares named conditions on a model that reads apply with Scope,
+// such as "active" or "admins".
+type Scoper interface {
+	Scopes() map[string]func(*bun.SelectQuery) *bun.SelectQuery
+}
+
+type SoftDeleter interface {
+	SoftDeleteColumn() string
+}
+
+func New(db *bun.DB, opts ...Option) (*Models, error) {
 	m := &Models{
-		db: db,
+		conn: db,
+		db:   db,
+		opts: options{
+			filterTag: "field",
+		},
 	}
 
+	switch db.Dialect().Name() {
+	case dialect.PG:
+		m.dialect = DialectPostgres
+	case dialect.MySQL:
+		m.dialect = DialectMySQL
+	case dialect.SQLite:
+		m.dialect = DialectSQLite
+	}
+
+	m.opts = m.options(opts)
+
 	return m, nil
 }
 
-func (m *Models) Create(ctx context.Context, v any) error {
+// ClaimNext locks and scans into v the first row matching args in primary key
+// order, ignoring OrderBy and the default order, skipping rows other
+// transactions have locked, so concurrent workers each claim a different row.
+// It must run inside a transaction and fails with ErrNotFound when no row is
+// claimable. SQLite has no row locks and the query is run without a locking
+// clause.
+func (m *Models) ClaimNext(ctx context.Context, v, args any, opts ...Option) error {
 	if reflect.TypeOf(v).Kind() != reflect.Ptr {
 		panic("pointer expected")
 	}
 
-	if err := m.db.NewInsert().Model(v).Scan(ctx); err != nil {
+	if _, ok := m.conn.(bun.Tx); !ok {
+		return errors.Errorf("claim requires a transaction")
+	}
+
+	o := m.options(opts)
+
+	q := withFields(m.newSelect(ctx, "ClaimNext", v, o), o)
+
+	if err := m.queryArgs(q, v, args, o); err != nil {
 		return errors.WithStack(err)
 	}
 
-	return nil
+	for _, pk := range m.table(v).PKs {
+		q = q.OrderExpr("?TableAlias.?", bun.Ident(pk.Name))
+	}
+
+	switch m.dialect {
+	case DialectPostgres, DialectMySQL:
+		q = q.For("UPDATE SKIP LOCKED")
+	}
+
+	if err := q.Limit(1).Scan(ctx); err != nil {
+		return notFound(errors.WithStack(err))
+	}
+
+	return m.decrypt(v, o)
 }
 
-func (m *Models) Delete(ctx context.Context, v any) error {
+func (m *Models) Create(ctx context.Context, v any, opts ...Option) error {
 	if reflect.TypeOf(v).Kind() != reflect.Ptr {
 		panic("pointer expected")
 	}
 
-	if _, err := m.db.NewDelete().Model(v).WherePK().Exec(ctx); err != nil {
-		return errors.WithStack(err)
+	_, err := m.create(ctx, v, m.options(opts))
+
+	return err
+}
+
+// create inserts v and returns the number of rows inserted, which excludes
+// rows skipped by IgnoreConflict.
+func (m *Models) create(ctx context.Context, v any, o options) (int64, error) {
+	if _, _, err := m.audit(ctx, v, o, true); err != nil {
+		return 0, err
 	}
 
-	return nil
+	restore, err := m.encrypt(v, o)
+	if err != nil {
+		return 0, err
+	}
+	defer restore()
+
+	q, err := m.withForceZero(m.newInsert("Create", v, o), v, o)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(o.excludeColumns) > 0 {
+		q = q.ExcludeColumn(o.excludeColumns...)
+	}
+
+	if len(o.returning) > 0 {
+		if !m.db.HasFeature(feature.InsertReturning) {
+			return 0, errors.Errorf("returning requires a dialect supporting INSERT ... RETURNING")
+		}
+
+		if err := m.checkColumns(v, o.returning); err != nil {
+			return 0, err
+		}
+
+		// an explicit RETURNING replaces the one bun adds for generated
+		// values, so keep returning the primary key and defaulted columns
+		returning := map[string]bool{}
+
+		for _, c := range o.returning {
+			returning[c] = true
+		}
+
+		for _, f := range m.table(v).Fields {
+			if f.IsPK || f.AutoIncrement || f.NullZero || f.SQLDefault != "" {
+				returning[f.Name] = true
+			}
+		}
+
+		for _, f := range m.table(v).Fields {
+			if returning[f.Name] {
+				q = q.Returning("?", bun.Ident(f.Name))
+			}
+		}
+	}
+
+	if ic, ok := insertCustomizer(v); ok {
+		q = ic.CustomizeInsert(q)
+	}
+
+	if o.ignoreConflict {
+		if m.dialect == DialectMySQL {
+			q = q.Ignore()
+		} else {
+			q = q.On("CONFLICT DO NOTHING")
+		}
+	}
+
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return n, nil
 }
 
-func (m *Models) Find(ctx context.Context, v, args any) error {
-	if reflect.TypeOf(v).Kind() != reflect.Ptr {
-		panic("pointer expected")
+// CreateMany creates every model in vs, a pointer to a slice, splitting them
+// over several statements in one transaction when there are too many values
+// for the dialect to accept in a single one. An empty vs is a no-op.
+func (m *Models) CreateMany(ctx context.Context, vs any, opts ...Option) error {
+	if err := checkSlice(vs); err != nil {
+		return err
 	}
 
-	q := m.db.NewSelect().Model(v)
+	return m.chunks(ctx, vs, func(tm *Models, chunk any) error {
+		return tm.Create(ctx, chunk, opts...)
+	})
+}
 
-	q = withQueryDefaults(q, v)
-	if qd, ok := v.(QueryDefaulter); ok {
-		q = qd.QueryDefault(q)
+// CreateManyResult is CreateMany but also returns the number of rows
+// inserted, so with IgnoreConflict it counts only the rows that were new.
+func (m *Models) CreateManyResult(ctx context.Context, vs any, opts ...Option) (int64, error) {
+	if err := checkSlice(vs); err != nil {
+		return 0, err
 	}
 
-	if err := queryArgs(q, args); err != nil {
-		return errors.WithStack(err)
+	var total int64
+
+	err := m.chunks(ctx, vs, func(tm *Models, chunk any) error {
+		n, err := tm.create(ctx, chunk, tm.options(opts))
+		total += n
+		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	if err := q.Scan(ctx); err != nil {
-		return errors.WithStack(err)
+	return total, nil
+}
+
+// CreateGraph creates each of vs in order inside a single transaction, so
+// either all rows are created or none are. An element may also be a func() any
+// returning the model to create, which runs once every earlier model has its
+// generated primary key, letting children reference their parent.
+func (m *Models) CreateGraph(ctx context.Context, vs ...any) error {
+	return m.Transaction(ctx, func(tm *Models) error {
+		for _, v := range vs {
+			if fn, ok := v.(func() any); ok {
+				v = fn()
+			}
+
+			if err := tm.Create(ctx, v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Compile renders q as parameterized SQL, returning its string, byte, time
+// and JSON values separately as args for tools that replay queries. bun writes
+// numbers and booleans directly, so those stay inline.
+func (m *Models) Compile(q *bun.SelectQuery) (string, []any, error) {
+	d := &compileDialect{Dialect: q.Dialect(), dialect: m.dialect}
+
+	b, err := q.AppendQuery(schema.NewFormatter(d), nil)
+	if err != nil {
+		return "", nil, errors.WithStack(err)
 	}
 
-	return nil
+	return string(b), d.args, nil
 }
 
-func (m *Models) Get(ctx context.Context, v any) error {
-	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+func (m *Models) Count(ctx context.Context, model any, args any, opts ...Option) (int, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
 		panic("pointer expected")
 	}
 
-	q := m.db.NewSelect().Model(v)
+	o := m.options(opts)
 
-	if qd, ok := v.(QueryDefaulter); ok {
-		q = qd.QueryDefault(q)
+	q := m.newSelect(ctx, "Count", model, o)
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return 0, errors.WithStack(err)
 	}
 
-	if err := q.WherePK().Scan(ctx); err != nil {
-		return errors.WithStack(err)
+	var n int
+
+	err := m.retry(ctx, o, func() (err error) {
+		n, err = q.Count(ctx)
+		return err
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
 	}
 
-	return nil
+	return n, nil
 }
 
-func (m *Models) List(ctx context.Context, vs any, args any) error {
-	if reflect.TypeOf(vs).Kind() != reflect.Ptr || reflect.TypeOf(vs).Elem().Kind() != reflect.Slice {
-		return errors.Errorf("pointer to slice expected")
+// CountBy counts the rows of model matching args per value of column. Values
+// are keyed as database/sql converts them to strings, with NULL as "".
+func (m *Models) CountBy(ctx context.Context, model any, column string, args any, opts ...Option) (map[string]int, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
 	}
 
-	q := m.db.NewSelect().Model(vs)
+	if err := m.checkColumns(model, []string{column}); err != nil {
+		return nil, err
+	}
 
-	v := reflect.New(reflect.TypeOf(vs).Elem()).Interface()
+	o := m.options(opts)
 
-	if qd, ok := v.(QueryDefaulter); ok {
-		q = qd.QueryDefault(q)
+	q := m.newSelect(ctx, "CountBy", model, o).
+		ColumnExpr("?TableAlias.?", bun.Ident(column)).
+		ColumnExpr("COUNT(*)").
+		GroupExpr("?TableAlias.?", bun.Ident(column))
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	if err := queryArgs(q, args); err != nil {
-		return errors.WithStack(err)
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
+	defer rows.Close()
 
-	if err := q.Scan(ctx); err != nil {
-		return errors.WithStack(err)
+	counts := map[string]int{}
+
+	c, encrypted := o.crypter(column)
+
+	for rows.Next() {
+		var key sql.NullString
+		var n int
+
+		if err := rows.Scan(&key, &n); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if encrypted {
+			if _, err := cryptValue(reflect.ValueOf(&key.String).Elem(), column, c, false); err != nil {
+				return nil, err
+			}
+		}
+
+		counts[key.String] += n
 	}
 
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return counts, nil
 }
 
-func (m *Models) Save(ctx context.Context, v any, columns ...string) error {
-	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+// CountDistinct counts the distinct values of column over the rows of model
+// matching args.
+func (m *Models) CountDistinct(ctx context.Context, model any, column string, args any, opts ...Option) (int, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
 		panic("pointer expected")
 	}
-	var md *bun.InsertQuery
 
-	switch t := v.(type) {
-	case *bun.InsertQuery:
-		md = t
-	default:
-		md = m.db.NewInsert().Model(t)
+	if err := m.checkColumns(model, []string{column}); err != nil {
+		return 0, err
 	}
 
-	md = md.On("CONFLICT (?PKs) DO UPDATE")
+	o := m.options(opts)
+
+	q := m.newSelect(ctx, "CountDistinct", model, o).ColumnExpr("COUNT(DISTINCT ?TableAlias.?)", bun.Ident(column))
 
-	if ups := m.updateColumns(v); ups != "" {
-		md = md.Set(ups)
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return 0, errors.WithStack(err)
 	}
 
-	for _, column := range columns {
-		md = md.Set(fmt.Sprintf("%q = EXCLUDED.%q", column, column))
+	var n int
+
+	if err := q.Scan(ctx, &n); err != nil {
+		return 0, errors.WithStack(err)
 	}
 
-	if _, err := md.Exec(ctx); err != nil {
+	return n, nil
+}
+
+// DB returns the handle Models runs its queries on: the transaction inside
+// Transaction and the database otherwise. Prefer going through Models where
+// possible so defaults and options keep applying.
+func (m *Models) DB() bun.IDB {
+	return m.conn
+}
+
+// Dialect reports the database dialect, resolved once by New.
+func (m *Models) Dialect() Dialect {
+	return m.dialect
+}
+
+// Decrement atomically subtracts delta from column on the row identified by
+// the primary key of v.
+func (m *Models) Decrement(ctx context.Context, v any, column string, delta int64, opts ...Option) error {
+	return m.Increment(ctx, v, column, -delta, opts...)
+}
+
+// Delete removes the row identified by the primary key of v. Models with a
+// soft delete column are marked deleted instead.
+func (m *Models) Delete(ctx context.Context, v any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	if f := m.softDeleteField(v, o); f != nil && f != m.table(v).SoftDeleteField {
+		deleted := m.trashedValue(f, o)
+
+		q := m.newUpdate("Delete", v, o).Set("? = ?", bun.Ident(f.Name), deleted).WherePK()
+
+		_, expr := deleted.(bun.Safe)
+
+		if expr && m.db.HasFeature(feature.Returning) {
+			q = q.Returning("?", bun.Ident(f.Name))
+		}
+
+		if _, err := q.Exec(ctx); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if expr {
+			return nil
+		}
+
+		if err := f.ScanValue(reflect.ValueOf(v).Elem(), deleted); err != nil {
+			return errors.WithStack(err)
+		}
+
+		return nil
+	}
+
+	if _, err := m.newDelete("Delete", v, o).WherePK().Exec(ctx); err != nil {
 		return errors.WithStack(err)
 	}
 
 	return nil
 }
 
-func (m *Models) Select(v any) *bun.SelectQuery {
-	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+// DeleteMany deletes the rows of model whose primary key is in ids, soft
+// deleting them when the model has a soft delete column, and returns how many
+// were affected. An empty ids is a no-op.
+func (m *Models) DeleteMany(ctx context.Context, model any, ids any, opts ...Option) (int64, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
 		panic("pointer expected")
 	}
 
-	q := m.db.NewSelect().Model(v)
+	if v := reflect.ValueOf(ids); v.Kind() == reflect.Slice && v.Len() == 0 {
+		return 0, nil
+	}
 
-	if qd, ok := v.(QueryDefaulter); ok {
-		q = qd.QueryDefault(q)
+	pks := m.table(model).PKs
+	if len(pks) != 1 {
+		return 0, errors.Errorf("single primary key expected: %T", model)
 	}
 
-	return q
-}
+	o := m.options(opts)
 
-func (m *Models) updateColumns(v interface{}, additional ...string) string {
-	updates := map[schema.Safe]bool{}
+	var res sql.Result
+	var err error
 
-	for _, a := range additional {
-		updates[bun.Safe(a)] = true
+	if f := m.softDeleteField(model, o); f != nil && f != m.table(model).SoftDeleteField {
+		res, err = m.newUpdate("DeleteMany", model, o).Set("? = ?", bun.Ident(f.Name), m.trashedValue(f, o)).Where("? IN (?)", bun.Ident(pks[0].Name), bun.In(ids)).Exec(ctx)
+	} else {
+		res, err = m.newDelete("DeleteMany", model, o).Where("? IN (?)", bun.Ident(pks[0].Name), bun.In(ids)).Exec(ctx)
 	}
 
-	for field, attrs := range modelTags(v) {
-		if attrs["update"] {
-			for _, f := range m.db.Dialect().Tables().Get(reflect.TypeOf(v)).Fields {
-				if f.GoName == field {
-					updates[f.SQLName] = true
-				}
-			}
-		}
+	if err != nil {
+		return 0, errors.WithStack(err)
 	}
 
-	statements := []string{}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
 
-	for k := range updates {
-		statements = append(statements, fmt.Sprintf(`%q = EXCLUDED.%q`, k, k))
+	return n, nil
+}
+
+// DeleteReturning is Delete but scans the deleted row back into v, so v holds
+// its final state. Not supported on MySQL.
+func (m *Models) DeleteReturning(ctx context.Context, v any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	if !m.db.HasFeature(feature.Returning) {
+		return errors.Errorf("delete returning requires a dialect supporting RETURNING")
+	}
+
+	o := m.options(opts)
+
+	var err error
+
+	if f := m.softDeleteField(v, o); f != nil && f != m.table(v).SoftDeleteField {
+		err = m.newUpdate("DeleteReturning", v, o).Set("? = ?", bun.Ident(f.Name), m.trashedValue(f, o)).WherePK().Returning("*").Scan(ctx)
+	} else {
+		err = m.newDelete("DeleteReturning", v, o).WherePK().Returning("*").Scan(ctx)
+	}
+
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
-	return strings.Join(statements, ",")
+	return m.decrypt(v, o)
 }
 
-func modelTags(v interface{}) map[string]map[string]bool {
-	tags := map[string]map[string]bool{}
+// Each streams the rows matching args, scanning each into a new value of the
+// type model points to and passing it to fn, until fn returns an error.
+func (m *Models) Each(ctx context.Context, model any, args any, fn func(row any) error, opts ...Option) error {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
 
-	t := reflect.TypeOf(v)
+	o := m.options(opts)
 
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
+	q := m.withRead(m.newSelect(ctx, "Each", model, o), args, o)
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return errors.WithStack(err)
 	}
 
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
-		if tag, ok := f.Tag.Lookup("model"); ok {
-			tags[f.Name] = map[string]bool{}
-			for _, attr := range strings.Split(tag, ",") {
-				tags[f.Name][strings.TrimSpace(attr)] = true
-			}
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	t := reflect.TypeOf(model).Elem()
+
+	for rows.Next() {
+		row := reflect.New(t).Interface()
+
+		if err := m.db.ScanRow(ctx, rows, row); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := m.decrypt(row, o); err != nil {
+			return err
+		}
+
+		if err := fn(row); err != nil {
+			return err
 		}
 	}
 
-	return tags
+	return errors.WithStack(rows.Err())
 }
 
-func queryArgs(q *bun.SelectQuery, args any) error {
-	argsv := reflect.ValueOf(args)
-	argst := reflect.TypeOf(args)
+// EachBatch walks the rows matching args in primary key order, scanning up to
+// size rows at a time into vs and passing the slice to fn, until fn returns an
+// error. Batches are fetched by keyset so later ones stay cheap.
+func (m *Models) EachBatch(ctx context.Context, vs any, args any, size int, fn func(batch any) error, opts ...Option) error {
+	if err := checkSlice(vs); err != nil {
+		return err
+	}
 
-	switch argsv.Kind() {
-	case reflect.Invalid:
-	case reflect.Struct:
-		for i := 0; i < argsv.NumField(); i++ {
-			if argsv.Field(i).Type().Kind() == reflect.Ptr && argsv.Field(i).IsNil() {
-				continue
-			}
+	if size <= 0 {
+		return errors.Errorf("batch size must be positive: %d", size)
+	}
 
-			if field := argst.Field(i).Tag.Get("field"); field != "" {
-				q = q.Where(fmt.Sprintf("%s = ?", field), argsv.Field(i).Interface())
-			}
+	pks := m.table(vs).PKs
+	if len(pks) != 1 {
+		return errors.Errorf("single primary key expected: %T", vs)
+	}
+
+	o := m.options(opts)
+
+	rv := reflect.ValueOf(vs).Elem()
+
+	var last any
+
+	for {
+		batch := reflect.New(rv.Type())
+
+		q := m.newSelect(ctx, "EachBatch", batch.Interface(), o)
+
+		if err := m.queryArgs(q, vs, args, o); err != nil {
+			return errors.WithStack(err)
 		}
-	default:
-		return errors.Errorf("invalid args type: %T", args)
+
+		if last != nil {
+			q = q.Where("?TableAlias.? > ?", bun.Ident(pks[0].Name), last)
+		}
+
+		if err := q.OrderExpr("?TableAlias.? ASC", bun.Ident(pks[0].Name)).Limit(size).Scan(ctx); err != nil {
+			return errors.WithStack(err)
+		}
+
+		n := batch.Elem().Len()
+
+		if n == 0 {
+			return nil
+		}
+
+		if err := m.decrypt(batch.Interface(), o); err != nil {
+			return err
+		}
+
+		rv.Set(batch.Elem())
+
+		if err := fn(rv.Interface()); err != nil {
+			return err
+		}
+
+		if n < size {
+			return nil
+		}
+
+		last = pks[0].Value(reflect.Indirect(rv.Index(n - 1))).Interface()
 	}
+}
 
-	return nil
+func (m *Models) Exists(ctx context.Context, model any, args any, opts ...Option) (bool, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.newSelect(ctx, "Exists", model, o)
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	var ok bool
+
+	err := m.retry(ctx, o, func() (err error) {
+		ok, err = q.Exists(ctx)
+		return err
+	})
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return ok, nil
 }
 
-func withQueryDefaults(q *bun.SelectQuery, v any) *bun.SelectQuery {
-	ve := reflect.New(reflect.TypeOf(v)).Elem().Interface()
+// ExistsPK reports whether the row identified by the primary key of v exists.
+func (m *Models) ExistsPK(ctx context.Context, v any, opts ...Option) (bool, error) {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	ok, err := m.newSelect(ctx, "ExistsPK", v, m.options(opts)).WherePK().Exists(ctx)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return ok, nil
+}
 
-	if qd, ok := ve.(QueryDefaulter); ok {
-		q = qd.QueryDefault(q)
+// Explain returns the SQL that Find or List would run for v and args without
+// executing it. Values are rendered inline.
+func (m *Models) Explain(ctx context.Context, v, args any, opts ...Option) (string, error) {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.withRead(m.newSelect(ctx, "Explain", v, o), args, o)
+
+	if err := m.queryArgs(q, v, args, o); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	b, err := q.AppendQuery(m.db.Formatter(), nil)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return string(b), nil
+}
+
+// ExplainPlan runs the query Find or List would run for v and args under
+// EXPLAIN and returns the plan, one line per row. On SQLite EXPLAIN QUERY PLAN
+// is used. The Analyze option executes the query to collect timings.
+func (m *Models) ExplainPlan(ctx context.Context, v, args any, opts ...Option) (string, error) {
+	query, err := m.Explain(ctx, v, args, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	o := m.options(opts)
+
+	prefix := "EXPLAIN"
+
+	switch {
+	case o.analyze && m.dialect != DialectPostgres:
+		return "", errors.Errorf("explain analyze requires postgres")
+	case o.analyze:
+		prefix = "EXPLAIN ANALYZE"
+	case m.dialect == DialectSQLite:
+		prefix = "EXPLAIN QUERY PLAN"
+	}
+
+	rows, err := m.conn.QueryContext(ctx, prefix+" "+query)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	lines := []string{}
+
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]any, len(columns))
+
+		for i := range values {
+			dest[i] = &values[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return "", errors.WithStack(err)
+		}
+
+		parts := make([]string, len(values))
+
+		for i, v := range values {
+			parts[i] = v.String
+		}
+
+		lines = append(lines, strings.Join(parts, "\t"))
+	}
+
+	if err := rows.Err(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// Find scans the first row matching args into v. Args may be nil, a filter
+// struct, a *Criteria, or a []any of those that are combined with AND.
+func (m *Models) Find(ctx context.Context, v, args any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.withRead(m.newSelect(ctx, "Find", v, o), args, o)
+
+	if err := m.queryArgs(q, v, args, o); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := m.retry(ctx, o, func() error { return q.Scan(ctx) }); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return m.decrypt(v, o)
+}
+
+// FindValue runs Find for a new T and returns it, failing with ErrNotFound when
+// no row matches args.
+func FindValue[T any](ctx context.Context, m *Models, args any, opts ...Option) (T, error) {
+	var v T
+
+	if err := m.Find(ctx, &v, args, opts...); err != nil {
+		var zero T
+		return zero, notFound(err)
+	}
+
+	return v, nil
+}
+
+// FindOne is like Find but fails with ErrMultipleResults when more than one
+// row matches args.
+func (m *Models) FindOne(ctx context.Context, v, args any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	vs := reflect.New(reflect.SliceOf(reflect.TypeOf(v).Elem()))
+
+	q := m.withRead(m.newSelect(ctx, "FindOne", vs.Interface(), o), args, o)
+
+	if err := m.queryArgs(q, v, args, o); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := q.Limit(2).Scan(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch vs.Elem().Len() {
+	case 0:
+		return errors.WithStack(sql.ErrNoRows)
+	case 1:
+		reflect.ValueOf(v).Elem().Set(vs.Elem().Index(0))
+		return m.decrypt(v, o)
+	default:
+		return errors.Wrapf(ErrMultipleResults, "%T", v)
+	}
+}
+
+func (m *Models) Get(ctx context.Context, v any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.newSelect(ctx, "Get", v, o)
+
+	if o.allRelations {
+		for _, name := range m.relations(v) {
+			q = q.Relation(name)
+		}
+	}
+
+	q = q.WherePK()
+
+	if err := m.retry(ctx, o, func() error { return q.Scan(ctx) }); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return m.decrypt(v, o)
+}
+
+// GetMany lists the rows whose primary key is in ids. Results are returned in
+// database order, which need not match the order of ids. An empty ids empties
+// vs without a query.
+func (m *Models) GetMany(ctx context.Context, vs any, ids any, opts ...Option) error {
+	if err := checkSlice(vs); err != nil {
+		return err
+	}
+
+	if v := reflect.ValueOf(ids); v.Kind() == reflect.Slice && v.Len() == 0 {
+		rv := reflect.ValueOf(vs).Elem()
+		rv.Set(reflect.MakeSlice(rv.Type(), 0, 0))
+		return nil
+	}
+
+	pks := m.table(vs).PKs
+	if len(pks) != 1 {
+		return errors.Errorf("single primary key expected: %T", vs)
+	}
+
+	o := m.options(opts)
+
+	q := m.newSelect(ctx, "GetMany", vs, o)
+
+	if err := q.Where("?TableAlias.? IN (?)", bun.Ident(pks[0].Name), bun.In(ids)).Scan(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return m.decrypt(vs, o)
+}
+
+// GetForUpdate behaves like Get but locks the selected row with SELECT ... FOR
+// UPDATE on dialects that support it. The lock is only held for the duration
+// of the surrounding transaction so it is meaningless when called outside one.
+// SQLite has no row locks and the query is run without a locking clause.
+func (m *Models) GetForUpdate(ctx context.Context, v any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.newSelect(ctx, "GetForUpdate", v, o)
+
+	switch m.dialect {
+	case DialectPostgres, DialectMySQL:
+		if o.skipLocked {
+			q = q.For("UPDATE SKIP LOCKED")
+		} else {
+			q = q.For("UPDATE")
+		}
+	}
+
+	if err := q.WherePK().Scan(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return m.decrypt(v, o)
+}
+
+// GetInto is Find for model, scanning the first row matching args into dest,
+// a struct with a subset of the columns of model or a single value, instead of
+// into a model.
+func (m *Models) GetInto(ctx context.Context, model, dest, args any, opts ...Option) error {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr || reflect.TypeOf(dest).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.withRead(m.newSelect(ctx, "GetInto", model, o), args, o)
+
+	if columns := m.intoColumns(model, dest); len(columns) > 0 {
+		q = q.Column(columns...)
+	}
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := m.retry(ctx, o, func() error { return q.Scan(ctx, dest) }); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if reflect.TypeOf(dest).Elem().Kind() != reflect.Struct {
+		return nil
+	}
+
+	return m.decrypt(dest, intoCrypters(o, m.table(dest)))
+}
+
+// Increment atomically adds delta to column on the row identified by the
+// primary key of v, returning ErrNotFound if no row matched.
+func (m *Models) Increment(ctx context.Context, v any, column string, delta int64, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	f, ok := m.table(v).FieldMap[column]
+	if !ok {
+		return errors.Wrapf(ErrUnknownColumn, "%s", column)
+	}
+
+	switch f.IndirectType.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		return errors.Errorf("numeric column expected: %s", column)
+	}
+
+	o := m.options(opts)
+
+	q := m.newUpdate("Increment", v, o).Set("? = ? + ?", bun.Ident(f.Name), bun.Ident(f.Name), delta)
+
+	res, err := q.WherePK().Exec(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	found, err := m.foundPK(ctx, "Increment", v, o, n)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return errors.WithStack(ErrNotFound)
+	}
+
+	return nil
+}
+
+// List scans the rows matching args into vs. Args are handled as in Find.
+// Existing elements of vs are replaced rather than appended to, so vs holds
+// exactly the rows matched, even when reused across calls.
+func (m *Models) List(ctx context.Context, vs any, args any, opts ...Option) error {
+	if err := checkSlice(vs); err != nil {
+		return err
+	}
+
+	o := m.options(opts)
+
+	q := m.withRead(m.newSelect(ctx, "List", vs, o), args, o)
+
+	if err := m.queryArgs(q, vs, args, o); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if o.scanError != nil {
+		if err := m.scanRows(ctx, q, vs, o.scanError); err != nil {
+			return err
+		}
+	} else if err := m.retry(ctx, o, func() error { return q.Scan(ctx) }); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return m.decrypt(vs, o)
+}
+
+// ListMap lists rows and indexes them by the key returned from keyFn. On
+// duplicate keys the last row wins unless UniqueKeys is given.
+func ListMap[K comparable, V any](ctx context.Context, m *Models, args any, keyFn func(V) K, opts ...Option) (map[K]V, error) {
+	vs := []V{}
+
+	if err := m.List(ctx, &vs, args, opts...); err != nil {
+		return nil, err
+	}
+
+	o := m.options(opts)
+
+	vm := make(map[K]V, len(vs))
+
+	for _, v := range vs {
+		k := keyFn(v)
+
+		if _, ok := vm[k]; ok && o.uniqueKeys {
+			return nil, errors.Wrapf(ErrDuplicateKey, "%v", k)
+		}
+
+		vm[k] = v
+	}
+
+	return vm, nil
+}
+
+// ListMore scans up to limit rows matching args into vs and reports whether
+// more rows follow, by fetching one extra row instead of counting. limit must
+// be positive.
+func (m *Models) ListMore(ctx context.Context, vs any, args any, limit int, opts ...Option) (bool, error) {
+	if err := checkSlice(vs); err != nil {
+		return false, err
+	}
+
+	if limit <= 0 {
+		return false, errors.Errorf("limit must be positive: %d", limit)
+	}
+
+	o := m.options(opts)
+
+	q := m.withRead(m.newSelect(ctx, "ListMore", vs, o), args, o)
+
+	if err := m.queryArgs(q, vs, args, o); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	if err := q.Limit(limit + 1).Scan(ctx); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	rv := reflect.ValueOf(vs).Elem()
+
+	more := rv.Len() > limit
+
+	if more {
+		rv.Set(rv.Slice(0, limit))
+	}
+
+	if err := m.decrypt(vs, o); err != nil {
+		return false, err
+	}
+
+	return more, nil
+}
+
+func (m *Models) ListN(ctx context.Context, vs any, args any, opts ...Option) (int, error) {
+	if err := m.List(ctx, vs, args, opts...); err != nil {
+		return 0, err
+	}
+
+	return reflect.ValueOf(vs).Elem().Len(), nil
+}
+
+// ListPage scans one page of the rows matching args into vs and returns the
+// total number of matching rows. Both queries share the same defaults and
+// filters.
+func (m *Models) ListPage(ctx context.Context, vs any, args any, page Page, opts ...Option) (int, error) {
+	if err := checkSlice(vs); err != nil {
+		return 0, err
+	}
+
+	o := m.options(opts)
+
+	q := m.withRead(m.newSelect(ctx, "ListPage", vs, o), args, o)
+
+	if err := m.queryArgs(q, vs, args, o); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if page.Limit > 0 {
+		q = q.Limit(page.Limit)
+	}
+
+	if page.Offset > 0 {
+		q = q.Offset(page.Offset)
+	}
+
+	total, err := q.ScanAndCount(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if err := m.decrypt(vs, o); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+// ListRaw selects the rows of table matching the column values in args into
+// maps keyed by column name, for tables without a model.
+func (m *Models) ListRaw(ctx context.Context, table string, args map[string]any) ([]map[string]any, error) {
+	if !validTable.MatchString(table) {
+		return nil, errors.Errorf("invalid table: %q", table)
+	}
+
+	q := m.conn.NewSelect().TableExpr("?", bun.Ident(table))
+
+	columns := make([]string, 0, len(args))
+
+	for c := range args {
+		columns = append(columns, c)
+	}
+
+	sort.Strings(columns)
+
+	for _, c := range columns {
+		q = q.Where("? = ?", bun.Ident(c), args[c])
+	}
+
+	rows := []map[string]any{}
+
+	if err := q.Scan(ctx, &rows); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return rows, nil
+}
+
+func (m *Models) Ping(ctx context.Context) error {
+	if err := m.db.PingContext(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Pluck scans the values of column over the rows of model matching args into
+// dest, a pointer to a slice such as *[]string.
+func (m *Models) Pluck(ctx context.Context, model any, column string, dest any, args any, opts ...Option) error {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	if t := reflect.TypeOf(dest); t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Slice {
+		return errors.WithStack(ErrNotSlicePointer)
+	}
+
+	if err := m.checkColumns(model, []string{column}); err != nil {
+		return err
+	}
+
+	o := m.options(opts)
+
+	q := m.withOrder(m.newSelect(ctx, "Pluck", model, o).ColumnExpr("?TableAlias.?", bun.Ident(column)), args, o)
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := q.Scan(ctx, dest); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if c, ok := o.crypter(column); ok {
+		rv := reflect.ValueOf(dest).Elem()
+
+		for i := 0; i < rv.Len(); i++ {
+			if _, err := cryptValue(rv.Index(i), column, c, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Purge permanently removes the rows of model that were soft deleted more than
+// olderThan ago and returns how many were removed.
+func (m *Models) Purge(ctx context.Context, model any, olderThan time.Duration, opts ...Option) (int64, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	f := m.softDeleteField(model, o)
+
+	switch {
+	case f == nil:
+		return 0, errors.Wrapf(ErrNoSoftDelete, "%T", model)
+	case f.IndirectType.Kind() == reflect.Bool:
+		return 0, errors.Errorf("purge requires a soft delete timestamp: %T", model)
+	}
+
+	q := m.newDelete("Purge", model, o).Where("? < ?", bun.Ident(f.Name), o.now().Add(-olderThan))
+
+	if f == m.table(model).SoftDeleteField {
+		q = q.WhereDeleted().ForceDelete()
+	}
+
+	if p := alivePredicate(f, o); p != "" {
+		q = q.Where("NOT (" + p + ")")
+	}
+
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return n, nil
+}
+
+// Refresh reloads v from the database by primary key, discarding any local
+// changes. Fields are only overwritten once the row has been read.
+func (m *Models) Refresh(ctx context.Context, v any) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	cur := reflect.ValueOf(v).Elem()
+	fresh := reflect.New(cur.Type())
+
+	for _, pk := range m.db.Dialect().Tables().Get(cur.Type()).PKs {
+		fresh.Elem().FieldByIndex(pk.Index).Set(cur.FieldByIndex(pk.Index))
+	}
+
+	if err := m.Get(ctx, fresh.Interface()); err != nil {
+		return notFound(err)
+	}
+
+	cur.Set(fresh.Elem())
+
+	return nil
+}
+
+// Register registers models with the underlying bun database, as needed for
+// many-to-many join models, so callers do not need the bun handle.
+func (m *Models) Register(models ...any) {
+	m.db.RegisterModel(models...)
+}
+
+// Restore clears the soft delete marker on the row identified by the primary
+// key of v and returns the number of rows restored.
+func (m *Models) Restore(ctx context.Context, v any, opts ...Option) (int64, error) {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	f := m.softDeleteField(v, o)
+	if f == nil {
+		return 0, errors.Wrapf(ErrNoSoftDelete, "%T", v)
+	}
+
+	if alivePredicate(f, o) != "" {
+		return 0, errors.Errorf("restore requires the default alive predicate: %T", v)
+	}
+
+	q := m.newUpdate("Restore", v, o).Set("? = ?", bun.Ident(f.Name), aliveValue(f)).WherePK()
+
+	if m.table(v).SoftDeleteField != nil {
+		q = q.WhereAllWithDeleted()
+	}
+
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	fv := f.Value(reflect.ValueOf(v).Elem())
+	fv.Set(reflect.Zero(fv.Type()))
+
+	return n, nil
+}
+
+func (m *Models) Save(ctx context.Context, v any, columns ...string) error {
+	return m.Upsert(ctx, v, OnConflictUpdate(columns...))
+}
+
+// SaveMany is Save for a pointer to a slice of models, split over statements
+// as in CreateMany.
+func (m *Models) SaveMany(ctx context.Context, vs any, columns ...string) error {
+	if err := checkSlice(vs); err != nil {
+		return err
+	}
+
+	return m.chunks(ctx, vs, func(tm *Models, chunk any) error {
+		return tm.Upsert(ctx, chunk, OnConflictUpdate(columns...))
+	})
+}
+
+// SaveManyResult is SaveMany but also reports, in the order of vs, whether
+// each row was inserted or updated. Rows are matched to vs by the columns of
+// the conflict target, as Postgres does not promise to return them in order,
+// and the primary keys the database returns are written back to vs. It
+// requires Postgres and fails when rows are skipped, such as under
+// EmptyUpdateNothing.
+func (m *Models) SaveManyResult(ctx context.Context, vs any, columns ...string) ([]SaveResult, error) {
+	if err := checkSlice(vs); err != nil {
+		return nil, err
+	}
+
+	if m.dialect != DialectPostgres {
+		return nil, errors.Errorf("save results require postgres")
+	}
+
+	o := m.options([]Option{OnConflictUpdate(columns...)})
+
+	fields, err := m.conflictFields(vs, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// the primary keys are returned too, so generated ones can be written back
+	// to the inserted rows
+	returned := append([]*schema.Field{}, fields...)
+	seen := map[*schema.Field]bool{}
+
+	for _, f := range fields {
+		seen[f] = true
+	}
+
+	for _, pk := range m.table(vs).PKs {
+		if !seen[pk] {
+			returned = append(returned, pk)
+		}
+	}
+
+	idents := make([]bun.Ident, len(returned))
+
+	for i, f := range returned {
+		idents[i] = bun.Ident(f.Name)
+	}
+
+	restore, err := m.encrypt(vs, o)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	results := []SaveResult{}
+
+	err = m.chunks(ctx, vs, func(tm *Models, chunk any) error {
+		q, err := tm.upsert(ctx, "SaveManyResult", chunk, o)
+		if err != nil {
+			return err
+		}
+
+		inserted := []bool{}
+		dest := []any{&inserted}
+		keys := make([]reflect.Value, len(returned))
+
+		for i, f := range returned {
+			keys[i] = reflect.New(reflect.SliceOf(reflect.PtrTo(f.IndirectType)))
+			dest = append(dest, keys[i].Interface())
+		}
+
+		if err := q.Returning("xmax = 0, ?", bun.In(idents)).Scan(ctx, dest...); err != nil {
+			return errors.WithStack(err)
+		}
+
+		rows := modelRows(chunk)
+
+		if len(inserted) != len(rows) {
+			return errors.Errorf("saved %d of %d rows", len(inserted), len(rows))
+		}
+
+		saved := map[any]int{}
+
+		for j := range inserted {
+			values := make([]reflect.Value, len(fields))
+
+			for i := range fields {
+				values[i] = keys[i].Elem().Index(j)
+			}
+
+			saved[conflictKey(values)] = j
+		}
+
+		for _, row := range rows {
+			values := make([]reflect.Value, len(fields))
+
+			for i, f := range fields {
+				values[i] = f.Value(row)
+			}
+
+			j, ok := saved[conflictKey(values)]
+			if !ok {
+				return errors.Errorf("saved row not returned: %+v", row.Interface())
+			}
+
+			for i := len(fields); i < len(returned); i++ {
+				if pk := keys[i].Elem().Index(j); !pk.IsNil() {
+					returned[i].Value(row).Set(pk.Elem())
+				}
+			}
+
+			if inserted[j] {
+				results = append(results, SaveInserted)
+			} else {
+				results = append(results, SaveUpdated)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// conflictKey identifies a row by the values of its conflict target columns,
+// returned as a comparable array so it can key a map. Times are compared in
+// UTC, as the database may return them in another location.
+func conflictKey(values []reflect.Value) any {
+	key := reflect.New(reflect.ArrayOf(len(values), reflect.TypeOf((*any)(nil)).Elem())).Elem()
+
+	for i, v := range values {
+		if v = reflect.Indirect(v); !v.IsValid() {
+			continue
+		}
+
+		switch x := v.Interface().(type) {
+		case time.Time:
+			key.Index(i).Set(reflect.ValueOf(x.UTC()))
+		case []byte:
+			key.Index(i).Set(reflect.ValueOf(string(x)))
+		default:
+			if v.Type().Comparable() {
+				key.Index(i).Set(v)
+			} else {
+				key.Index(i).Set(reflect.ValueOf(fmt.Sprintf("%v", x)))
+			}
+		}
+	}
+
+	return key.Interface()
+}
+
+// Savepoint runs fn inside a savepoint of the current transaction, rolling
+// back to it if fn fails while leaving the rest of the transaction intact. It
+// can only be used on the Models passed to a Transaction callback.
+func (m *Models) Savepoint(ctx context.Context, name string, fn func(*Models) error) error {
+	if _, ok := m.conn.(bun.Tx); !ok {
+		return errors.Errorf("savepoint requires a transaction")
+	}
+
+	if _, err := m.conn.ExecContext(ctx, "SAVEPOINT ?", bun.Ident(name)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := fn(m); err != nil {
+		if _, rerr := m.conn.ExecContext(ctx, "ROLLBACK TO SAVEPOINT ?", bun.Ident(name)); rerr != nil {
+			return errors.WithStack(rerr)
+		}
+
+		return err
+	}
+
+	if _, err := m.conn.ExecContext(ctx, "RELEASE SAVEPOINT ?", bun.Ident(name)); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Scalar selects the single value of expr, such as MAX(version), over the rows
+// of model matching args and scans it into dest.
+func (m *Models) Scalar(ctx context.Context, model, dest any, expr string, args any, opts ...Option) error {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr || reflect.TypeOf(dest).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	q := m.newSelect(ctx, "Scalar", model, o).ColumnExpr(expr)
+
+	if err := m.queryArgs(q, model, args, o); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := q.Scan(ctx, dest); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Select returns a query for v with its defaults applied. No context is
+// available here so a ContextQueryDefaulter receives context.Background().
+func (m *Models) Select(v any) *bun.SelectQuery {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	return m.newSelect(context.Background(), "Select", v, m.opts)
+}
+
+// SelectWith is like Select but also applies opts, such as Where, OrderBy,
+// Relation, Columns or NoDefaults, as Find and List would.
+func (m *Models) SelectWith(v any, opts ...Option) *bun.SelectQuery {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	return m.withRead(m.newSelect(context.Background(), "SelectWith", v, o), nil, o)
+}
+
+// SelectInto scans the results of q, typically built from Select with joins,
+// into dest, such as a slice of an ad hoc struct.
+func (m *Models) SelectInto(ctx context.Context, q *bun.SelectQuery, dest any) error {
+	if err := q.Scan(ctx, dest); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// SoftDeleteWhere marks every row of model matching args as deleted and
+// returns how many were marked. It fails with ErrNoFilters when args adds no
+// filter, unless args is All.
+func (m *Models) SoftDeleteWhere(ctx context.Context, model any, args any, opts ...Option) (int64, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	f := m.softDeleteField(model, o)
+	if f == nil {
+		return 0, errors.Wrapf(ErrNoSoftDelete, "%T", model)
+	}
+
+	sub, err := m.matchingPKs(ctx, "SoftDeleteWhere", model, args, o)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := m.newUpdate("SoftDeleteWhere", model, o).Set("? = ?", bun.Ident(f.Name), m.trashedValue(f, o)).Where("(?PKs) IN (?)", sub).Exec(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return rows, nil
+}
+
+// Touch sets the updated timestamp of the row identified by the primary key of
+// v without writing any other column. The column is the field tagged
+// model:"updated" or the one set with WithUpdatedColumn.
+func (m *Models) Touch(ctx context.Context, v any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	f := m.updatedField(v, o)
+	if f == nil {
+		return errors.Wrapf(ErrNoUpdatedColumn, "%T", v)
+	}
+
+	now := m.currentTime(o)
+
+	q := m.newUpdate("Touch", v, o).Set("? = ?", bun.Ident(f.Name), now).WherePK()
+
+	if _, ok := now.(bun.Safe); ok && m.db.HasFeature(feature.Returning) {
+		q = q.Returning("?", bun.Ident(f.Name))
+	}
+
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	found, err := m.foundPK(ctx, "Touch", v, o, n)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return errors.WithStack(ErrNotFound)
+	}
+
+	if t, ok := now.(time.Time); ok {
+		setTime(f.Value(reflect.ValueOf(v).Elem()), t)
+	}
+
+	return nil
+}
+
+// Transaction runs fn with a Models bound to a new transaction, committing if
+// fn returns nil and rolling back otherwise.
+func (m *Models) Transaction(ctx context.Context, fn func(*Models) error) error {
+	err := m.conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		c := *m
+		c.conn = tx
+
+		return fn(&c)
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Update writes v by primary key. When columns are given only those are
+// updated, otherwise every column is written.
+func (m *Models) Update(ctx context.Context, v any, columns ...string) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	if err := m.checkColumns(v, columns); err != nil {
+		return err
+	}
+
+	_, updatedBy, err := m.audit(ctx, v, m.opts, false)
+	if err != nil {
+		return err
+	}
+
+	if updatedBy && len(columns) > 0 {
+		columns = append(columns[:len(columns):len(columns)], "updated_by")
+	}
+
+	restore, err := m.encrypt(v, m.opts)
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	q := m.newUpdate("Update", v, m.opts).WherePK()
+
+	if len(columns) > 0 {
+		q = q.Column(columns...)
+	}
+
+	if _, err := q.Exec(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// UpdateColumns sets only the columns in changes on the row identified by the
+// primary key of v and returns the number of rows affected. v itself is not
+// modified.
+func (m *Models) UpdateColumns(ctx context.Context, v any, changes map[string]any) (int64, error) {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	if len(changes) == 0 {
+		return 0, errors.Wrapf(ErrNoUpdateColumns, "%T", v)
+	}
+
+	columns := make([]string, 0, len(changes))
+
+	for c := range changes {
+		columns = append(columns, c)
+	}
+
+	sort.Strings(columns)
+
+	if err := m.checkColumns(v, columns); err != nil {
+		return 0, err
+	}
+
+	changes, err := encryptChanges(changes, m.opts)
+	if err != nil {
+		return 0, err
+	}
+
+	q := m.newUpdate("UpdateColumns", v, m.opts).WherePK()
+
+	for _, c := range columns {
+		q = q.Set("? = ?", bun.Ident(c), changes[c])
+	}
+
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return n, nil
+}
+
+// UpdateWhere sets the columns in changes on every row of model matching args
+// and returns the number of rows affected. It fails with ErrNoFilters when
+// args adds no filter, unless args is All.
+func (m *Models) UpdateWhere(ctx context.Context, model any, changes map[string]any, args any, opts ...Option) (int64, error) {
+	if reflect.TypeOf(model).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	if len(changes) == 0 {
+		return 0, errors.Wrapf(ErrNoUpdateColumns, "%T", model)
+	}
+
+	columns := make([]string, 0, len(changes))
+
+	for c := range changes {
+		columns = append(columns, c)
+	}
+
+	sort.Strings(columns)
+
+	if err := m.checkColumns(model, columns); err != nil {
+		return 0, err
+	}
+
+	o := m.options(opts)
+
+	changes, err := encryptChanges(changes, o)
+	if err != nil {
+		return 0, err
+	}
+
+	sub, err := m.matchingPKs(ctx, "UpdateWhere", model, args, o)
+	if err != nil {
+		return 0, err
+	}
+
+	q := m.newUpdate("UpdateWhere", model, o).Where("(?PKs) IN (?)", sub)
+
+	for _, c := range columns {
+		q = q.Set("? = ?", bun.Ident(c), changes[c])
+	}
+
+	res, err := q.Exec(ctx)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return n, nil
+}
+
+// Upsert inserts v or updates it when its primary key already exists. The
+// columns tagged model:"update" and those given with OnConflictUpdate are
+// updated; when there are none WithEmptyUpdate decides what happens.
+func (m *Models) Upsert(ctx context.Context, v any, opts ...Option) error {
+	if reflect.TypeOf(v).Kind() != reflect.Ptr {
+		panic("pointer expected")
+	}
+
+	o := m.options(opts)
+
+	if _, ok := v.(*bun.InsertQuery); !ok {
+		restore, err := m.encrypt(v, o)
+		if err != nil {
+			return err
+		}
+		defer restore()
+	}
+
+	md, err := m.upsert(ctx, "Upsert", v, o)
+	if err != nil {
+		return err
+	}
+
+	if _, err := md.Exec(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+func (m *Models) upsert(ctx context.Context, op string, v any, o options) (*bun.InsertQuery, error) {
+	var md *bun.InsertQuery
+
+	createdBy := false
+
+	switch t := v.(type) {
+	case *bun.InsertQuery:
+		md = t
+	default:
+		if err := m.checkRegistered(v); err != nil {
+			return nil, err
+		}
+
+		var updatedBy bool
+		var err error
+
+		createdBy, updatedBy, err = m.audit(ctx, v, o, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if updatedBy && len(m.updateColumns(v, o)) > 0 {
+			o.conflictUpdate = extend(o.conflictUpdate, "updated_by")
+		}
+
+		if md, err = m.withForceZero(m.newInsert(op, t, o), v, o); err != nil {
+			return nil, err
+		}
+	}
+
+	_, isQuery := v.(*bun.InsertQuery)
+
+	columns := m.updateColumns(v, o)
+
+	target, targetArgs, err := m.conflictTarget(v, o)
+	if err != nil {
+		return nil, err
+	}
+
+	update := true
+
+	switch {
+	case len(columns) > 0:
+		md = md.On(target+" DO UPDATE", targetArgs...)
+
+		for _, column := range columns {
+			md = md.Set("? = EXCLUDED.?", bun.Ident(column), bun.Ident(column))
+		}
+	case o.emptyUpdate == EmptyUpdateError:
+		return nil, errors.Wrapf(ErrNoUpdateColumns, "%T", v)
+	case o.emptyUpdate == EmptyUpdateNothing, !isQuery && len(m.table(v).DataFields) == 0:
+		md = md.On(target+" DO NOTHING", targetArgs...)
+		update = false
+	case createdBy:
+		// spell out the columns bun would update so an existing row keeps
+		// who created it
+		md = md.On(target+" DO UPDATE", targetArgs...)
+
+		excluded := map[string]bool{"created_by": true}
+
+		for _, e := range o.excludeColumns {
+			excluded[e] = true
+		}
+
+		for _, f := range m.table(v).DataFields {
+			if !excluded[f.Name] {
+				md = md.Set("? = EXCLUDED.?", bun.Ident(f.Name), bun.Ident(f.Name))
+			}
+		}
+	default:
+		md = md.On(target+" DO UPDATE", targetArgs...)
+	}
+
+	if update && len(o.conflictWhere) > 0 {
+		if m.dialect == DialectMySQL {
+			return nil, errors.Errorf("conditional upserts are not supported by mysql")
+		}
+
+		for _, w := range o.conflictWhere {
+			md = md.Where(w.query, w.args...)
+		}
+	}
+
+	if len(o.excludeColumns) > 0 {
+		md = md.ExcludeColumn(o.excludeColumns...)
+	}
+
+	if ic, ok := insertCustomizer(v); ok && !isQuery {
+		md = ic.CustomizeInsert(md)
+	}
+
+	return md, nil
+}
+
+// conflictTarget returns the ON CONFLICT target of an upsert of v, the
+// primary key unless ConflictTarget names a partial index or ConflictOn a bun
+// unique group or a column tagged unique.
+func (m *Models) conflictTarget(v any, o options) (string, []any, error) {
+	if o.conflictColumn == "" && o.conflictOn == "" {
+		return "CONFLICT (?PKs)", nil, nil
+	}
+
+	if o.conflictColumn != "" && m.dialect == DialectMySQL {
+		return "", nil, errors.Errorf("partial conflict targets are not supported by mysql")
+	}
+
+	columns := []bun.Ident{bun.Ident(o.conflictColumn)}
+
+	if _, ok := v.(*bun.InsertQuery); ok {
+		if o.conflictColumn == "" {
+			return "", nil, errors.Errorf("conflict groups require a model")
+		}
+	} else {
+		fields, err := m.conflictFields(v, o)
+		if err != nil {
+			return "", nil, err
+		}
+
+		columns = make([]bun.Ident, len(fields))
+
+		for i, f := range fields {
+			columns[i] = bun.Ident(f.Name)
+		}
+	}
+
+	target := "CONFLICT (?)"
+
+	if o.conflictColumn != "" {
+		predicate := strings.TrimSpace(o.conflictPartial)
+
+		if len(predicate) >= 6 && strings.EqualFold(predicate[:6], "WHERE ") {
+			predicate = strings.TrimSpace(predicate[6:])
+		}
+
+		if predicate != "" {
+			target += " WHERE " + predicate
+		}
+	}
+
+	return target, []any{bun.In(columns)}, nil
+}
+
+// conflictFields returns the columns of the ON CONFLICT target of an upsert
+// of v, the model itself rather than a query.
+func (m *Models) conflictFields(v any, o options) ([]*schema.Field, error) {
+	table := m.table(v)
+
+	switch {
+	case o.conflictColumn != "":
+		f, ok := table.FieldMap[o.conflictColumn]
+		if !ok {
+			return nil, errors.Wrapf(ErrUnknownColumn, "%s", o.conflictColumn)
+		}
+
+		return []*schema.Field{f}, nil
+	case o.conflictOn != "":
+		if fields, ok := table.Unique[o.conflictOn]; ok {
+			return fields, nil
+		}
+
+		for _, f := range table.Unique[""] {
+			if f.Name == o.conflictOn {
+				return []*schema.Field{f}, nil
+			}
+		}
+
+		return nil, errors.Errorf("unknown unique group: %s", o.conflictOn)
+	default:
+		return table.PKs, nil
+	}
+}
+
+// With returns a copy of m with opts applied on top of its current options.
+// The copy shares the underlying database handle.
+func (m *Models) With(opts ...Option) *Models {
+	c := *m
+	c.opts = m.options(opts)
+
+	return &c
+}
+
+// audit fills the created_by and updated_by columns of v, a model or a
+// pointer to a slice of models, from the WithAuditColumns callback. Only
+// updated_by is filled unless create is set. It reports whether created_by
+// and updated_by were filled.
+func (m *Models) audit(ctx context.Context, v any, o options, create bool) (bool, bool, error) {
+	if o.audit == nil {
+		return false, false, nil
+	}
+
+	createdBy, updatedBy, ok := o.audit(ctx)
+	if !ok {
+		return false, false, nil
+	}
+
+	table := m.table(v)
+	cf, uf := table.FieldMap["created_by"], table.FieldMap["updated_by"]
+
+	for _, row := range modelRows(v) {
+		if create && cf != nil {
+			if err := cf.ScanValue(row, createdBy); err != nil {
+				return false, false, errors.WithStack(err)
+			}
+		}
+
+		if uf != nil {
+			if err := uf.ScanValue(row, updatedBy); err != nil {
+				return false, false, errors.WithStack(err)
+			}
+		}
+	}
+
+	return create && cf != nil, uf != nil, nil
+}
+
+// crypt encrypts or decrypts the crypter columns of every row of v in place
+// and returns a func restoring their previous values. Empty values are left
+// alone.
+func (m *Models) crypt(v any, o options, encrypt bool) (func(), error) {
+	var undo []func()
+
+	restore := func() {
+		for _, u := range undo {
+			u()
+		}
+	}
+
+	if len(o.crypters) == 0 {
+		return restore, nil
+	}
+
+	table := m.table(v)
+
+	for _, c := range o.crypters {
+		for _, column := range c.columns {
+			f, ok := table.FieldMap[column]
+			if !ok {
+				restore()
+				return nil, errors.Wrapf(ErrUnknownColumn, "%s", column)
+			}
+
+			for _, row := range modelRows(v) {
+				u, err := cryptValue(f.Value(row), column, c, encrypt)
+				if err != nil {
+					restore()
+					return nil, err
+				}
+
+				undo = append(undo, u)
+			}
+		}
+	}
+
+	return restore, nil
+}
+
+// cryptValue encrypts or decrypts fv, the string or []byte value of column,
+// in place and returns a func restoring its previous value. Empty values are
+// left alone.
+func cryptValue(fv reflect.Value, column string, c crypter, encrypt bool) (func(), error) {
+	fn := c.decrypt
+	if encrypt {
+		fn = c.encrypt
+	}
+
+	var in []byte
+
+	switch {
+	case fv.Kind() == reflect.String:
+		in = []byte(fv.String())
+	case fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8:
+		in = fv.Bytes()
+	default:
+		return nil, errors.Errorf("encrypted column must be a string or []byte: %s", column)
+	}
+
+	if len(in) == 0 {
+		return func() {}, nil
+	}
+
+	out, err := fn(in)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	prev := reflect.New(fv.Type()).Elem()
+	prev.Set(fv)
+
+	if fv.Kind() == reflect.String {
+		fv.SetString(string(out))
+	} else {
+		fv.SetBytes(out)
+	}
+
+	return func() { fv.Set(prev) }, nil
+}
+
+// encryptChanges returns a copy of changes with the values of crypter columns
+// encrypted, for writes that take their values from a map.
+func encryptChanges(changes map[string]any, o options) (map[string]any, error) {
+	out := make(map[string]any, len(changes))
+
+	for column, v := range changes {
+		out[column] = v
+
+		c, ok := o.crypter(column)
+		if !ok || v == nil {
+			continue
+		}
+
+		fv := reflect.New(reflect.TypeOf(v)).Elem()
+		fv.Set(reflect.ValueOf(v))
+
+		if _, err := cryptValue(fv, column, c, true); err != nil {
+			return nil, err
+		}
+
+		out[column] = fv.Interface()
+	}
+
+	return out, nil
+}
+
+// intoCrypters narrows the crypters of o to the columns of table, for scanning
+// into a struct that holds only some of the columns of a model.
+func intoCrypters(o options, table *schema.Table) options {
+	crypters := []crypter{}
+
+	for _, c := range o.crypters {
+		columns := []string{}
+
+		for _, column := range c.columns {
+			if _, ok := table.FieldMap[column]; ok {
+				columns = append(columns, column)
+			}
+		}
+
+		if len(columns) > 0 {
+			c.columns = columns
+			crypters = append(crypters, c)
+		}
+	}
+
+	o.crypters = crypters
+
+	return o
+}
+
+// decrypt replaces the crypter columns of every row of v with their
+// plaintext after a read.
+func (m *Models) decrypt(v any, o options) error {
+	_, err := m.crypt(v, o, false)
+	return err
+}
+
+// encrypt replaces the crypter columns of every row of v with their
+// ciphertext ahead of a write. The returned func restores the plaintext.
+func (m *Models) encrypt(v any, o options) (func(), error) {
+	return m.crypt(v, o, true)
+}
+
+// retry runs fn, running it again up to the WithAutoRetry count while it fails
+// with a transient connection error. Inside a transaction fn is only run once
+// as the transaction is lost along with its connection.
+func (m *Models) retry(ctx context.Context, o options, fn func() error) error {
+	err := fn()
+
+	if _, ok := m.conn.(bun.Tx); ok {
+		return err
+	}
+
+	for i := 0; i < o.retries && transient(err); i++ {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(time.Duration(i+1) * 10 * time.Millisecond):
+		}
+
+		err = fn()
+	}
+
+	return err
+}
+
+func transient(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone)
+}
+
+// bindLimits is the number of values each dialect accepts in one statement.
+var bindLimits = map[Dialect]int{
+	DialectMySQL:    65535,
+	DialectPostgres: 65535,
+	DialectSQLite:   32766,
+}
+
+// chunks runs fn over consecutive parts of vs, a pointer to a slice, each
+// small enough that one value per column of each row stays within the
+// dialect's limit. Parts share the elements of vs and run in one transaction
+// when there is more than one. An empty vs runs nothing.
+func (m *Models) chunks(ctx context.Context, vs any, fn func(tm *Models, chunk any) error) error {
+	rv := reflect.ValueOf(vs).Elem()
+
+	if rv.Len() == 0 {
+		return nil
+	}
+
+	size := rv.Len()
+
+	if limit, ok := bindLimits[m.dialect]; ok {
+		if columns := len(m.table(vs).Fields); columns > 0 {
+			size = limit / columns
+		}
+	}
+
+	if rv.Len() <= size {
+		return fn(m, vs)
+	}
+
+	run := func(tm *Models) error {
+		for i := 0; i < rv.Len(); i += size {
+			j := i + size
+			if j > rv.Len() {
+				j = rv.Len()
+			}
+
+			chunk := reflect.New(rv.Type())
+			chunk.Elem().Set(rv.Slice(i, j))
+
+			if err := fn(tm, chunk.Interface()); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if _, ok := m.conn.(bun.Tx); ok {
+		return run(m)
+	}
+
+	return m.Transaction(ctx, run)
+}
+
+// scanRows scans the rows of q into vs one at a time, passing rows that fail
+// to scan to onError instead of failing.
+func (m *Models) scanRows(ctx context.Context, q *bun.SelectQuery, vs any, onError func(error)) error {
+	rows, err := q.Rows(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer rows.Close()
+
+	rv := reflect.ValueOf(vs).Elem()
+	rv.Set(reflect.MakeSlice(rv.Type(), 0, 0))
+
+	et := rv.Type().Elem()
+	st := et
+
+	if st.Kind() == reflect.Ptr {
+		st = st.Elem()
+	}
+
+	for rows.Next() {
+		row := reflect.New(st)
+
+		if err := m.db.ScanRow(ctx, rows, row.Interface()); err != nil {
+			onError(errors.WithStack(err))
+			continue
+		}
+
+		if et.Kind() != reflect.Ptr {
+			row = row.Elem()
+		}
+
+		rv.Set(reflect.Append(rv, row))
+	}
+
+	return errors.WithStack(rows.Err())
+}
+
+// withForceZero writes the ForceZero columns of v with their actual values,
+// even when zero, instead of letting bun fall back to NULL or the default.
+func (m *Models) withForceZero(q *bun.InsertQuery, v any, o options) (*bun.InsertQuery, error) {
+	if len(o.forceZero) == 0 {
+		return q, nil
+	}
+
+	if reflect.TypeOf(v).Elem().Kind() != reflect.Struct {
+		return nil, errors.Errorf("force zero requires a single model: %T", v)
+	}
+
+	if err := m.checkColumns(v, o.forceZero); err != nil {
+		return nil, err
+	}
+
+	rv := reflect.ValueOf(v).Elem()
+	table := m.table(v)
+
+	forced := map[string]bool{}
+
+	for _, c := range o.forceZero {
+		forced[c] = true
+		q = q.Value(c, "?", table.FieldMap[c].Value(rv).Interface())
+	}
+
+	// bun leaves NOT NULL columns that would fall back to their default out of
+	// the INSERT entirely on some dialects, so list the columns explicitly
+	columns := []string{}
+
+	for _, f := range table.Fields {
+		zero := (f.IsPtr && f.HasNilValue(rv)) || (f.HasZeroValue(rv) && (f.NullZero || f.SQLDefault != ""))
+
+		if forced[f.Name] || f.AutoIncrement || !f.NotNull || !zero {
+			columns = append(columns, f.Name)
+		}
+	}
+
+	return q.Column(columns...), nil
+}
+
+func (m *Models) updateColumns(v interface{}, o options) []string {
+	updates := map[string]bool{}
+
+	for _, a := range o.conflictUpdate {
+		updates[a] = true
+	}
+
+	for _, c := range m.taggedUpdateColumns(v) {
+		updates[c] = true
+	}
+
+	for _, e := range o.excludeColumns {
+		delete(updates, e)
+	}
+
+	columns := []string{}
+
+	for k := range updates {
+		columns = append(columns, k)
+	}
+
+	sort.Strings(columns)
+
+	return columns
+}
+
+func checkSlice(vs any) error {
+	t := reflect.TypeOf(vs)
+
+	if t == nil || t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Slice {
+		return errors.WithStack(ErrNotSlicePointer)
+	}
+
+	et := t.Elem().Elem()
+
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+
+	if et.Kind() != reflect.Struct {
+		return errors.WithStack(ErrNotSlicePointer)
+	}
+
+	return nil
+}
+
+// checkRegistered makes sure bun has usable table metadata for v, a struct
+// with a primary key, before building queries that depend on it.
+func (m *Models) checkRegistered(v any) error {
+	if reflect.TypeOf(modelValue(v)).Elem().Kind() != reflect.Struct || len(m.table(v).PKs) == 0 {
+		return errors.Wrapf(ErrModelNotRegistered, "%T", v)
+	}
+
+	return nil
+}
+
+func (m *Models) checkColumns(v any, columns []string) error {
+	fields := m.table(v).FieldMap
+
+	for _, c := range columns {
+		if _, ok := fields[c]; !ok {
+			return errors.Wrapf(ErrUnknownColumn, "%s", c)
+		}
+	}
+
+	return nil
+}
+
+func (m *Models) intoColumns(model, dest any) []string {
+	dt := reflect.TypeOf(dest).Elem()
+
+	if dt.Kind() != reflect.Struct {
+		return nil
+	}
+
+	mt := m.db.Dialect().Tables().Get(reflect.TypeOf(model).Elem())
+
+	columns := []string{}
+
+	for _, f := range m.db.Dialect().Tables().Get(dt).Fields {
+		if _, ok := mt.FieldMap[f.Name]; ok {
+			columns = append(columns, f.Name)
+		}
+	}
+
+	return columns
+}
+
+func (m *Models) taggedField(v any, attr string) *schema.Field {
+	for field, attrs := range modelTags(modelValue(v)) {
+		if attrs[attr] {
+			for _, f := range m.table(v).Fields {
+				if f.GoName == field {
+					return f
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (m *Models) updatedField(v any, o options) *schema.Field {
+	if f := m.taggedField(v, "updated"); f != nil {
+		return f
+	}
+
+	return m.table(v).FieldMap[o.updatedColumn]
+}
+
+func setTime(fv reflect.Value, t time.Time) {
+	switch fv.Type() {
+	case reflect.TypeOf(t):
+		fv.Set(reflect.ValueOf(t))
+	case reflect.TypeOf(&t):
+		fv.Set(reflect.ValueOf(&t))
+	}
+}
+
+var (
+	modelTagsCache     sync.Map
+	updateColumnsCache sync.Map
+)
+
+func (m *Models) taggedUpdateColumns(v interface{}) []string {
+	v = modelValue(v)
+	t := reflect.TypeOf(v)
+
+	if c, ok := updateColumnsCache.Load(t); ok {
+		return c.([]string)
+	}
+
+	columns := []string{}
+
+	for field, attrs := range modelTags(v) {
+		if attrs["update"] {
+			for _, f := range m.db.Dialect().Tables().Get(t).Fields {
+				if f.GoName == field {
+					columns = append(columns, f.Name)
+				}
+			}
+		}
+	}
+
+	updateColumnsCache.Store(t, columns)
+
+	return columns
+}
+
+func modelTags(v interface{}) map[string]map[string]bool {
+	t := reflect.TypeOf(v)
+
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if tags, ok := modelTagsCache.Load(t); ok {
+		return tags.(map[string]map[string]bool)
+	}
+
+	tags := map[string]map[string]bool{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		attrs := map[string]bool{}
+
+		for _, attr := range strings.Split(f.Tag.Get("model"), ",") {
+			if attr = strings.TrimSpace(attr); attr != "" {
+				attrs[attr] = true
+			}
+		}
+
+		if len(attrs) > 0 {
+			tags[f.Name] = attrs
+		}
+	}
+
+	modelTagsCache.Store(t, tags)
+
+	return tags
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return errors.WithStack(ErrNotFound)
+	}
+
+	return err
+}
+
+// foundPK reports whether an UPDATE of v by primary key that affected n rows
+// found its row. MySQL counts only the rows it changed, so there a row left
+// unchanged is looked up instead.
+func (m *Models) foundPK(ctx context.Context, op string, v any, o options, n int64) (bool, error) {
+	if n > 0 || m.dialect != DialectMySQL {
+		return n > 0, nil
+	}
+
+	ok, err := withTable(m.conn.NewSelect().Model(v), o, true, m.queryComment(op, v, o)).WherePK().Exists(ctx)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return ok, nil
+}
+
+// matchingPKs returns a query selecting the primary keys of the rows of model
+// matching args, for statements that must not run unfiltered. It fails with
+// ErrNoFilters when args adds no filter, unless args is All.
+func (m *Models) matchingPKs(ctx context.Context, op string, model, args any, o options) (*bun.SelectQuery, error) {
+	pks := []string{}
+
+	for _, pk := range m.table(model).PKs {
+		pks = append(pks, pk.Name)
+	}
+
+	sub := m.newSelect(ctx, op, model, o).Column(pks...)
+
+	n, err := m.applyArgs(sub, model, args, o)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if n == 0 && args != All {
+		return nil, errors.Wrapf(ErrNoFilters, "%T", model)
+	}
+
+	// MySQL refuses to select from the table being updated in a subquery
+	// (error 1093) unless it is materialized as a derived table first
+	if m.dialect == DialectMySQL {
+		sub = m.conn.NewSelect().ColumnExpr("*").TableExpr("(?) AS ?", sub, bun.Ident("matching"))
+	}
+
+	return sub, nil
+}
+
+func (m *Models) queryArgs(q *bun.SelectQuery, v, args any, o options) error {
+	_, err := m.applyArgs(q, v, args, o)
+	return err
+}
+
+// applyArgs adds the filters in args to q and returns how many it added.
+func (m *Models) applyArgs(q *bun.SelectQuery, v, args any, o options) (int, error) {
+	if _, ok := args.(allRows); ok {
+		return 0, nil
+	}
+
+	if c, ok := args.(*Criteria); ok {
+		if c == nil {
+			return 0, nil
+		}
+
+		if o.strictFilters {
+			for _, cc := range c.conds {
+				if err := m.checkFilterColumn(v, cc.column); err != nil {
+					return 0, err
+				}
+			}
+		}
+
+		c.apply(q)
+
+		return len(c.conds), nil
+	}
+
+	argsv := reflect.ValueOf(args)
+	argst := reflect.TypeOf(args)
+
+	n := 0
+
+	switch argsv.Kind() {
+	case reflect.Invalid:
+	case reflect.Slice:
+		all, ok := args.([]any)
+		if !ok {
+			return 0, errors.Errorf("invalid args type: %T", args)
+		}
+
+		for _, a := range all {
+			an, err := m.applyArgs(q, v, a, o)
+			if err != nil {
+				return 0, err
+			}
+
+			n += an
+		}
+	case reflect.Struct:
+		for _, f := range filters(argst, o.filterTag) {
+			if f.err != nil {
+				return 0, f.err
+			}
+
+			fv := argsv.Field(f.index)
+
+			isNil := fv.Kind() == reflect.Ptr && fv.IsNil()
+
+			if isNil && f.op != "nulleq" {
+				continue
+			}
+
+			if fl, ok := fv.Interface().(Filterable); ok && !isNil && fl.OmitFromQuery() {
+				continue
+			}
+
+			if ev, ok := fv.Interface().(EnumValidator); ok && !isNil && !ev.Valid() {
+				if o.skipInvalidEnums {
+					continue
+				}
+
+				return 0, errors.Wrapf(ErrInvalidEnum, "%s: %v", f.column, reflect.Indirect(fv).Interface())
+			}
+
+			if o.strictFilters {
+				if err := m.checkFilterColumn(v, f.column); err != nil {
+					return 0, err
+				}
+			}
+
+			if err := m.filterWhere(q, f, fv.Interface()); err != nil {
+				return 0, err
+			}
+
+			n++
+		}
+	default:
+		return 0, errors.Errorf("invalid args type: %T", args)
+	}
+
+	return n, nil
+}
+
+func (m *Models) checkFilterColumn(v any, field string) error {
+	column, _, _ := strings.Cut(field, "->>")
+	table := m.table(v)
+
+	if alias, name, ok := strings.Cut(strings.TrimSpace(column), "."); ok {
+		if _, found := table.FieldMap[name]; found && alias == table.Alias {
+			return nil
+		}
+
+		for _, rel := range table.Relations {
+			if _, found := rel.JoinTable.FieldMap[name]; found && rel.Field.Name == alias {
+				return nil
+			}
+		}
+
+		return errors.Wrapf(ErrUnknownColumn, "%s", field)
+	}
+
+	if _, ok := table.FieldMap[strings.TrimSpace(column)]; !ok {
+		return errors.Wrapf(ErrUnknownColumn, "%s", field)
+	}
+
+	return nil
+}
+
+// compileDialect collects values in place of appending them, writing a
+// placeholder instead.
+type compileDialect struct {
+	schema.Dialect
+	dialect Dialect
+	args    []any
+}
+
+func (d *compileDialect) placeholder(b []byte, v any) []byte {
+	d.args = append(d.args, v)
+
+	if d.dialect == DialectPostgres {
+		return strconv.AppendInt(append(b, '$'), int64(len(d.args)), 10)
+	}
+
+	return append(b, '?')
+}
+
+func (d *compileDialect) AppendBytes(b []byte, bs []byte) []byte {
+	if bs == nil {
+		return dialect.AppendNull(b)
+	}
+
+	return d.placeholder(b, append([]byte(nil), bs...))
+}
+
+func (d *compileDialect) AppendJSON(b, jsonb []byte) []byte {
+	return d.placeholder(b, string(jsonb))
+}
+
+func (d *compileDialect) AppendString(b []byte, s string) []byte {
+	return d.placeholder(b, s)
+}
+
+func (d *compileDialect) AppendTime(b []byte, tm time.Time) []byte {
+	return d.placeholder(b, tm)
+}
+
+type filter struct {
+	index  int
+	column string
+	fn     string
+	op     string
+	err    error
+}
+
+type filtersKey struct {
+	t   reflect.Type
+	tag string
+}
+
+var filtersCache sync.Map
+
+func filters(t reflect.Type, tag string) []filter {
+	key := filtersKey{t: t, tag: tag}
+
+	if fs, ok := filtersCache.Load(key); ok {
+		return fs.([]filter)
+	}
+
+	fs := []filter{}
+
+	for i := 0; i < t.NumField(); i++ {
+		if f := parseFilter(t.Field(i), tag); f.column != "" {
+			f.index = i
+			fs = append(fs, f)
+		}
+	}
+
+	filtersCache.Store(key, fs)
+
+	return fs
+}
+
+type filterOrder struct {
+	filter
+	dir string
+}
+
+var ordersCache sync.Map
+
+var orderTag = regexp.MustCompile(`^(ASC|DESC)( NULLS (FIRST|LAST))?$`)
+
+// argsOrders collects the fields of args tagged order:"asc" or order:"desc",
+// optionally followed by nulls first or nulls last. The column is parsed from
+// the filter tag as for filters, or else taken from the bun tag.
+func argsOrders(args any, tag string) []filterOrder {
+	if all, ok := args.([]any); ok {
+		orders := []filterOrder{}
+
+		for _, a := range all {
+			orders = append(orders, argsOrders(a, tag)...)
+		}
+
+		return orders
+	}
+
+	t := reflect.TypeOf(args)
+
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	key := filtersKey{t: t, tag: tag}
+
+	if fo, ok := ordersCache.Load(key); ok {
+		return fo.([]filterOrder)
+	}
+
+	orders := []filterOrder{}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		dir := strings.ToUpper(strings.Join(strings.Fields(f.Tag.Get("order")), " "))
+		if !orderTag.MatchString(dir) {
+			continue
+		}
+
+		pf := parseFilter(f, tag)
+
+		if pf.column == "" && pf.err == nil {
+			column, _, _ := strings.Cut(f.Tag.Get("bun"), ",")
+
+			if column = strings.TrimSpace(column); column != "-" {
+				pf.column = column
+			}
+		}
+
+		if pf.column != "" || pf.err != nil {
+			orders = append(orders, filterOrder{filter: pf, dir: dir})
+		}
+	}
+
+	ordersCache.Store(key, orders)
+
+	return orders
+}
+
+// filterExpr matches the expressions a filter tagged expr may compare, a
+// single column wrapped in one of a few functions.
+var filterExpr = regexp.MustCompile(`^(?i:(LOWER|UPPER|TRIM|LENGTH|DATE))\(\s*([A-Za-z_][A-Za-z0-9_]*)\s*\)$`)
+
+func parseFilter(f reflect.StructField, tag string) filter {
+	parts := strings.Split(f.Tag.Get(tag), ",")
+
+	pf := filter{column: strings.TrimSpace(parts[0])}
+
+	if pf.column == "-" {
+		return filter{}
+	}
+
+	for _, p := range parts[1:] {
+		switch p = strings.TrimSpace(p); p {
+		case "expr":
+			if m := filterExpr.FindStringSubmatch(pf.column); m != nil {
+				pf.fn, pf.column = strings.ToUpper(m[1]), m[2]
+			} else {
+				pf.err = errors.Errorf("invalid filter expression: %q", pf.column)
+			}
+		case "ilike", "like", "nulleq":
+			pf.op = p
+		}
+	}
+
+	return pf
+}
+
+func (m *Models) filterWhere(q *bun.SelectQuery, f filter, value any) error {
+	if column, key, ok := strings.Cut(f.column, "->>"); ok {
+		if m.dialect != DialectPostgres {
+			return errors.Errorf("json path filters require postgres: %s", f.column)
+		}
+
+		q.Where("?TableAlias.?->>? = ?", bun.Ident(strings.TrimSpace(column)), strings.TrimSpace(key), value)
+
+		return nil
+	}
+
+	column := f.column
+
+	// dotted paths such as author.name refer to a joined relation, anything
+	// else to the model itself
+	if strings.Contains(column, ".") {
+		column = string(dialect.AppendIdent(nil, column, q.Dialect().IdentQuote()))
+	} else {
+		column = "?TableAlias." + column
+	}
+
+	if f.fn != "" {
+		column = f.fn + "(" + column + ")"
+	}
+
+	switch f.op {
+	case "ilike":
+		pattern := fmt.Sprintf("%%%v%%", reflect.Indirect(reflect.ValueOf(value)).Interface())
+
+		if m.dialect == DialectPostgres {
+			q.Where(fmt.Sprintf("%s ILIKE ?", column), pattern)
+		} else {
+			q.Where(fmt.Sprintf("LOWER(%s) LIKE LOWER(?)", column), pattern)
+		}
+	case "like":
+		q.Where(fmt.Sprintf("%s LIKE ?", column), value)
+	case "nulleq":
+		if m.dialect == DialectPostgres {
+			q.Where(fmt.Sprintf("%s IS NOT DISTINCT FROM ?", column), value)
+		} else {
+			q.Where(fmt.Sprintf("(%[1]s = ? OR (%[1]s IS NULL AND ? IS NULL))", column), value, value)
+		}
+	default:
+		q.Where(fmt.Sprintf("%s = ?", column), value)
+	}
+
+	return nil
+}
+
+// modelRows returns the struct values of v, a pointer to a model or to a slice
+// of models or model pointers.
+func modelRows(v any) []reflect.Value {
+	rv := reflect.ValueOf(v).Elem()
+
+	if rv.Kind() != reflect.Slice {
+		return []reflect.Value{rv}
+	}
+
+	rows := make([]reflect.Value, 0, rv.Len())
+
+	for i := 0; i < rv.Len(); i++ {
+		rows = append(rows, reflect.Indirect(rv.Index(i)))
+	}
+
+	return rows
+}
+
+func modelValue(v any) any {
+	t := reflect.TypeOf(v).Elem()
+
+	if t.Kind() != reflect.Slice {
+		return v
+	}
+
+	if t = t.Elem(); t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return reflect.New(t).Interface()
+}
+
+func insertCustomizer(v any) (InsertCustomizer, bool) {
+	if ic, ok := v.(InsertCustomizer); ok {
+		return ic, true
+	}
+
+	ic, ok := modelValue(v).(InsertCustomizer)
+
+	return ic, ok
+}
+
+func queryDefaulter(v any) (QueryDefaulter, bool) {
+	if qd, ok := v.(QueryDefaulter); ok {
+		return qd, true
+	}
+
+	qd, ok := modelValue(v).(QueryDefaulter)
+
+	return qd, ok
+}
+
+func contextQueryDefaulter(v any) (ContextQueryDefaulter, bool) {
+	if qd, ok := v.(ContextQueryDefaulter); ok {
+		return qd, true
+	}
+
+	qd, ok := modelValue(v).(ContextQueryDefaulter)
+
+	return qd, ok
+}
+
+// softDeleteField resolves the soft delete column of v from, in order, a
+// SoftDeleter, WithSoftDeleteColumn, a model:"softdelete" tag and bun's own
+// soft_delete tag.
+func (m *Models) softDeleteField(v any, o options) *schema.Field {
+	table := m.table(v)
+
+	if sd, ok := modelValue(v).(SoftDeleter); ok {
+		return table.FieldMap[sd.SoftDeleteColumn()]
+	}
+
+	if f, ok := table.FieldMap[o.softDeleteColumn]; ok {
+		return f
+	}
+
+	if f := m.taggedField(v, "softdelete"); f != nil {
+		return f
+	}
+
+	return table.SoftDeleteField
+}
+
+// alivePredicate returns the condition set with WithSoftDelete for live rows
+// of f, if any.
+func alivePredicate(f *schema.Field, o options) string {
+	if f.Name != o.softDeleteColumn {
+		return ""
+	}
+
+	return o.softDeleteAlive
+}
+
+func aliveValue(f *schema.Field) any {
+	if f.IndirectType.Kind() == reflect.Bool {
+		return false
+	}
+
+	return nil
+}
+
+func (m *Models) trashedValue(f *schema.Field, o options) any {
+	if f.Name == o.softDeleteColumn && o.softDeleteValue != nil {
+		return o.softDeleteValue
+	}
+
+	if f.IndirectType.Kind() == reflect.Bool {
+		return true
+	}
+
+	return m.currentTime(o)
+}
+
+// currentTime is the value written for the current time: o.now(), or the
+// database's own clock with WithNowExpression.
+func (m *Models) currentTime(o options) any {
+	if !o.nowExpression {
+		return o.now()
+	}
+
+	if m.dialect == DialectPostgres {
+		return bun.Safe("now()")
+	}
+
+	return bun.Safe("CURRENT_TIMESTAMP")
+}
+
+func (m *Models) table(v any) *schema.Table {
+	return m.db.Dialect().Tables().Get(reflect.TypeOf(modelValue(v)).Elem())
+}
+
+func (m *Models) relations(v any) []string {
+	names := []string{}
+
+	for name := range m.table(v).Relations {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+func (m *Models) newDelete(op string, v any, o options) *bun.DeleteQuery {
+	q := m.conn.NewDelete().Model(v)
+
+	return withTable(q, o, m.db.HasFeature(feature.DeleteTableAlias), m.queryComment(op, v, o))
+}
+
+func (m *Models) newInsert(op string, v any, o options) *bun.InsertQuery {
+	q := m.conn.NewInsert().Model(v)
+
+	return withTable(q, o, m.db.HasFeature(feature.InsertTableAlias), m.queryComment(op, v, o))
+}
+
+func (m *Models) newSelect(ctx context.Context, op string, v any, o options) *bun.SelectQuery {
+	q := withTable(m.conn.NewSelect().Model(v), o, true, m.queryComment(op, v, o))
+
+	if o.indexHint != "" {
+		q = withIndexHint(q, o.indexHint)
+	}
+
+	if len(o.wheres) > 0 {
+		q = q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			for _, w := range o.wheres {
+				if err := w.check(m.dialect); err != nil {
+					return q.Err(err)
+				}
+
+				if w.or {
+					q = q.WhereOr(w.query, w.args...)
+				} else {
+					q = q.Where(w.query, w.args...)
+				}
+			}
+
+			return q
+		})
+	}
+
+	for _, name := range o.scopes {
+		sc, _ := modelValue(v).(Scoper)
+		if sc == nil {
+			return q.Err(errors.Errorf("unknown scope: %s", name))
+		}
+
+		scope, ok := sc.Scopes()[name]
+		if !ok {
+			return q.Err(errors.Errorf("unknown scope: %s", name))
+		}
+
+		q = scope(q)
+	}
+
+	return m.withQueryDefaults(ctx, q, v, o)
+}
+
+func (m *Models) newUpdate(op string, v any, o options) *bun.UpdateQuery {
+	q := m.conn.NewUpdate().Model(v)
+
+	return withTable(q, o, m.db.HasFeature(feature.UpdateTableAlias), m.queryComment(op, v, o))
+}
+
+var commentUnsafe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+func (m *Models) queryComment(op string, v any, o options) string {
+	if !o.queryComment {
+		return ""
+	}
+
+	model := commentUnsafe.ReplaceAllString(m.table(v).Name, "")
+
+	return fmt.Sprintf("/* op=%s model=%s */ ", commentUnsafe.ReplaceAllString(op, ""), model)
+}
+
+type tableQuery[Q any] interface {
+	Err(error) Q
+	ModelTableExpr(string, ...any) Q
+}
+
+var (
+	validSchema = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	validTable  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+)
+
+func withTable[Q tableQuery[Q]](q Q, o options, alias bool, comment string) Q {
+	expr := "?TableName"
+	args := []any{}
+
+	switch {
+	case o.table != "" && !validTable.MatchString(o.table):
+		return q.Err(errors.Errorf("invalid table: %q", o.table))
+	case o.schema != "" && !validSchema.MatchString(o.schema):
+		return q.Err(errors.Errorf("invalid schema: %q", o.schema))
+	case o.table != "":
+		expr = "?"
+		args = append(args, bun.Ident(o.table))
+	case comment == "" && o.schema == "":
+		return q
+	}
+
+	if o.schema != "" && !strings.Contains(o.table, ".") {
+		expr = "?." + expr
+		args = append([]any{bun.Ident(o.schema)}, args...)
+	}
+
+	if alias {
+		expr += " AS ?TableAlias"
+	}
+
+	return q.ModelTableExpr(comment+expr, args...)
+}
+
+var validIndexHint = regexp.MustCompile(`^(?i:(USE|FORCE|IGNORE) INDEX) ?\(([A-Za-z_][A-Za-z0-9_]*(?:, ?[A-Za-z_][A-Za-z0-9_]*)*)\)$`)
+
+// withIndexHint applies an IndexHint, which bun only renders on MySQL.
+func withIndexHint(q *bun.SelectQuery, hint string) *bun.SelectQuery {
+	m := validIndexHint.FindStringSubmatch(strings.TrimSpace(hint))
+	if m == nil {
+		return q.Err(errors.Errorf("invalid index hint: %q", hint))
+	}
+
+	indexes := strings.Split(strings.ReplaceAll(m[2], " ", ""), ",")
+
+	switch strings.ToUpper(m[1]) {
+	case "FORCE":
+		return q.ForceIndex(indexes...)
+	case "IGNORE":
+		return q.IgnoreIndex(indexes...)
+	default:
+		return q.UseIndex(indexes...)
+	}
+}
+
+// withRead applies the options shaping the rows returned by reads: relations,
+// columns and order.
+func (m *Models) withRead(q *bun.SelectQuery, args any, o options) *bun.SelectQuery {
+	return m.withOrder(withFields(q, o), args, o)
+}
+
+// withFields applies the options choosing what reads load: relations and
+// columns.
+func withFields(q *bun.SelectQuery, o options) *bun.SelectQuery {
+	for _, r := range o.relations {
+		if r.apply != nil {
+			q = q.Relation(r.name, r.apply)
+		} else {
+			q = q.Relation(r.name)
+		}
+	}
+
+	if len(o.columns) > 0 {
+		q = q.Column(o.columns...)
+	}
+
+	return q
+}
+
+var validOrder = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*( (?i:ASC|DESC))?( (?i:NULLS (FIRST|LAST)))?(, ?[A-Za-z_][A-Za-z0-9_.]*( (?i:ASC|DESC))?( (?i:NULLS (FIRST|LAST)))?)*$`)
+
+// orderNulls matches an ORDER BY item, a column that may be quoted, qualified
+// or wrapped in a function, with a NULLS FIRST or NULLS LAST modifier.
+var orderNulls = regexp.MustCompile("(?i)((?:\\w+\\()?" + orderIdent + "(?:\\." + orderIdent + ")*\\)?)((?: (?:ASC|DESC))?) NULLS (FIRST|LAST)")
+
+const orderIdent = "(?:\\w+|\"[^\"]+\"|`[^`]+`)"
+
+// withOrderExpr adds order to q. MySQL has no NULLS FIRST or NULLS LAST, so
+// there they are emulated by first sorting on whether the column IS NULL.
+func (m *Models) withOrderExpr(q *bun.SelectQuery, order string) *bun.SelectQuery {
+	if m.dialect == DialectMySQL {
+		order = orderNulls.ReplaceAllStringFunc(order, func(item string) string {
+			m := orderNulls.FindStringSubmatch(item)
+
+			nulls := "ASC"
+			if strings.EqualFold(m[3], "FIRST") {
+				nulls = "DESC"
+			}
+
+			return m[1] + " IS NULL " + nulls + ", " + m[1] + m[2]
+		})
+	}
+
+	return q.OrderExpr(order)
+}
+
+// withOrder applies the per-call order, falling back to the order tags of the
+// args filters and then the default order.
+func (m *Models) withOrder(q *bun.SelectQuery, args any, o options) *bun.SelectQuery {
+	for _, r := range o.rank {
+		if err := r.check(m.dialect); err != nil {
+			return q.Err(err)
+		}
+
+		q = q.OrderExpr(r.query, r.args...)
+	}
+
+	if o.order == "" {
+		if orders := argsOrders(args, o.filterTag); len(orders) > 0 {
+			for _, fo := range orders {
+				if fo.err != nil {
+					return q.Err(fo.err)
+				}
+
+				if column, key, ok := strings.Cut(fo.column, "->>"); ok {
+					if m.dialect != DialectPostgres {
+						return q.Err(errors.Errorf("json path orders require postgres: %s", fo.column))
+					}
+
+					q = q.OrderExpr("?->>? "+fo.dir, bun.Ident(strings.TrimSpace(column)), strings.TrimSpace(key))
+					continue
+				}
+
+				column := string(dialect.AppendIdent(nil, fo.column, q.Dialect().IdentQuote()))
+
+				if fo.fn != "" {
+					column = fo.fn + "(" + column + ")"
+				}
+
+				q = m.withOrderExpr(q, column+" "+fo.dir)
+			}
+
+			return q
+		}
+	}
+
+	order := o.defaultOrder
+
+	if o.noDefaults {
+		order = ""
+	}
+
+	if o.order != "" {
+		order = o.order
+	}
+
+	switch {
+	case order == "":
+		return q
+	case !validOrder.MatchString(order):
+		return q.Err(errors.Errorf("invalid order: %q", order))
+	}
+
+	return m.withOrderExpr(q, order)
+}
+
+func (m *Models) withQueryDefaults(ctx context.Context, q *bun.SelectQuery, v any, o options) *bun.SelectQuery {
+	f := m.softDeleteField(v, o)
+	native := f != nil && f == m.table(v).SoftDeleteField
+
+	switch {
+	case o.onlyTrashed && f == nil:
+		return q.Err(errors.Wrapf(ErrNoSoftDelete, "%T", v))
+	case o.onlyTrashed && native:
+		q = q.WhereDeleted()
+	case o.onlyTrashed && alivePredicate(f, o) != "":
+		q = q.Where("NOT (" + alivePredicate(f, o) + ")")
+	case o.onlyTrashed && f.IndirectType.Kind() == reflect.Bool:
+		q = q.Where("?TableAlias.? = ?", bun.Ident(f.Name), true)
+	case o.onlyTrashed:
+		q = q.Where("?TableAlias.? IS NOT NULL", bun.Ident(f.Name))
+	case (o.withTrashed || o.noDefaults) && native:
+		q = q.WhereAllWithDeleted()
+	case o.withTrashed || o.noDefaults:
+	case f != nil && !native && alivePredicate(f, o) != "":
+		q = q.Where(alivePredicate(f, o))
+	case f != nil && !native && f.IndirectType.Kind() == reflect.Bool:
+		q = q.Where("?TableAlias.? = ?", bun.Ident(f.Name), false)
+	case f != nil && !native:
+		q = q.Where("?TableAlias.? IS NULL", bun.Ident(f.Name))
+	}
+
+	if o.noDefaults {
+		return q
+	}
+
+	// WithTrashed also skips the model's own defaults, while OnlyTrashed only
+	// swaps the soft delete condition
+	if !o.withTrashed || o.onlyTrashed {
+		if qd, ok := queryDefaulter(v); ok {
+			q = qd.QueryDefault(q)
+		}
+
+		if qd, ok := contextQueryDefaulter(v); ok {
+			q = qd.QueryDefaultContext(ctx, q)
+		}
+	}
+
+	if cf, ok := modelValue(v).(ContextFilterer); ok {
+		for _, c := range cf.ContextFilters(ctx) {
+			switch c.Op {
+			case "", "ilike", "like", "nulleq":
+			default:
+				return q.Err(errors.Errorf("invalid filter op: %q", c.Op))
+			}
+
+			if err := m.filterWhere(q, filter{column: c.Column, op: c.Op}, c.Value); err != nil {
+				return q.Err(err)
+			}
+		}
 	}
 
 	return q
@@ -0,0 +1,4619 @@
+package stdmodel
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/sqliteshim"
+	"github.com/uptrace/bun/schema"
+)
+
+type testModel struct {
+	bun.BaseModel `bun:"table:test_models"`
+
+	ID      int64  `bun:"id,pk,autoincrement"`
+	Name    string `bun:"name" model:"update"`
+	Email   string `bun:"email"`
+	Status  string `bun:"status"`
+	Version int64  `bun:"version"`
+}
+
+type testModelWithMultipleTags struct {
+	ID      int64  `bun:"id,pk,autoincrement"`
+	Name    string `bun:"name" model:"update"`
+	Status  string `bun:"status" model:"update"`
+	Version int64  `bun:"version"`
+}
+
+type testQueryArgs struct {
+	Name   *string `field:"name"`
+	Status *string `field:"status"`
+}
+
+// queryLog records the SQL of every query run on a database.
+type queryLog struct {
+	mu      sync.Mutex
+	queries []string
+}
+
+func (l *queryLog) BeforeQuery(ctx context.Context, e *bun.QueryEvent) context.Context {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.queries = append(l.queries, e.Query)
+
+	return ctx
+}
+
+func (l *queryLog) AfterQuery(ctx context.Context, e *bun.QueryEvent) {}
+
+func (l *queryLog) last() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.queries) == 0 {
+		return ""
+	}
+
+	return l.queries[len(l.queries)-1]
+}
+
+func (l *queryLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.queries = nil
+}
+
+// mysqlDialect is SQLite reporting itself as MySQL, to check the SQL built for
+// MySQL without a server.
+type mysqlDialect struct {
+	*sqlitedialect.Dialect
+}
+
+func (mysqlDialect) Name() dialect.Name {
+	return dialect.MySQL
+}
+
+var databases int64
+
+func openSQLite(t testing.TB, driverName string) *sql.DB {
+	t.Helper()
+
+	name := fmt.Sprintf("file:stdmodel%d?mode=memory&cache=shared", atomic.AddInt64(&databases, 1))
+
+	sqldb, err := sql.Open(driverName, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sqldb.SetMaxOpenConns(1)
+
+	t.Cleanup(func() { sqldb.Close() })
+
+	return sqldb
+}
+
+func newTestDB(t testing.TB, d schema.Dialect) (*bun.DB, *queryLog) {
+	t.Helper()
+
+	db := bun.NewDB(openSQLite(t, sqliteshim.ShimName), d)
+
+	log := &queryLog{}
+	db.AddQueryHook(log)
+
+	return db, log
+}
+
+// testSQLite returns an in-memory SQLite database.
+func testSQLite(t testing.TB) (*bun.DB, *queryLog) {
+	t.Helper()
+
+	return newTestDB(t, sqlitedialect.New())
+}
+
+// testPostgres returns an in-memory SQLite database behind the Postgres
+// dialect, for tests of the SQL built for Postgres. Statements SQLite does not
+// understand fail when run.
+func testPostgres(t testing.TB) (*bun.DB, *queryLog) {
+	t.Helper()
+
+	return newTestDB(t, pgdialect.New())
+}
+
+// testMySQL is testPostgres for MySQL.
+func testMySQL(t testing.TB) (*bun.DB, *queryLog) {
+	t.Helper()
+
+	return newTestDB(t, mysqlDialect{sqlitedialect.New()})
+}
+
+func testModels(t testing.TB, db *bun.DB, opts ...Option) *Models {
+	t.Helper()
+
+	m, err := New(db, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func createTables(t testing.TB, db *bun.DB, models ...any) {
+	t.Helper()
+
+	for _, model := range models {
+		if _, err := db.NewCreateTable().Model(model).Exec(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func insertRows(t testing.TB, db *bun.DB, rows ...any) {
+	t.Helper()
+
+	for _, row := range rows {
+		if _, err := db.NewInsert().Model(row).Exec(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// seedTestModels creates test_models with a row per name.
+func seedTestModels(t testing.TB, db *bun.DB, names ...string) {
+	t.Helper()
+
+	createTables(t, db, (*testModel)(nil))
+
+	for _, name := range names {
+		insertRows(t, db, &testModel{Name: name, Email: name + "@example.com"})
+	}
+}
+
+func assertContains(t testing.TB, s string, subs ...string) {
+	t.Helper()
+
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			t.Fatalf("expected %q in %s", sub, s)
+		}
+	}
+}
+
+func assertNotContains(t testing.TB, s string, subs ...string) {
+	t.Helper()
+
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			t.Fatalf("unexpected %q in %s", sub, s)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestGetForUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	pg, log := testPostgres(t)
+	m := testModels(t, pg)
+
+	m.GetForUpdate(ctx, &testModel{ID: 1})
+	assertContains(t, log.last(), "FOR UPDATE")
+
+	m.GetForUpdate(ctx, &testModel{ID: 1}, SkipLocked())
+	assertContains(t, log.last(), "FOR UPDATE SKIP LOCKED")
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "a")
+	m = testModels(t, db)
+
+	v := &testModel{ID: 1}
+
+	if err := m.GetForUpdate(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "a" {
+		t.Fatalf("unexpected name: %q", v.Name)
+	}
+
+	assertNotContains(t, log.last(), "FOR UPDATE")
+}
+
+func TestGetInto(t *testing.T) {
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b")
+	m := testModels(t, db)
+
+	var dto struct {
+		ID   int64
+		Name string
+	}
+
+	if err := m.GetInto(context.Background(), (*testModel)(nil), &dto, testQueryArgs{Name: strPtr("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if dto.ID != 2 || dto.Name != "b" {
+		t.Fatalf("unexpected dto: %+v", dto)
+	}
+
+	if err := m.GetInto(context.Background(), (*testModel)(nil), &dto, nil, Where("name = ?", "a")); err != nil {
+		t.Fatal(err)
+	}
+
+	if dto.ID != 1 || dto.Name != "a" {
+		t.Fatalf("per-call options not applied: %+v", dto)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a")
+	m := testModels(t, db)
+
+	v := &testModel{ID: 1, Name: "local", Status: "local"}
+
+	if err := m.Refresh(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "a" || v.Email != "a@example.com" || v.Status != "" {
+		t.Fatalf("local changes kept: %+v", v)
+	}
+
+	missing := &testModel{ID: 9, Name: "local"}
+
+	if err := m.Refresh(ctx, missing); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if missing.Name != "local" {
+		t.Fatalf("missing row overwritten: %+v", missing)
+	}
+}
+
+func TestWithFilterTag(t *testing.T) {
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b")
+	m := testModels(t, db, WithFilterTag("json"))
+
+	type args struct {
+		Name *string `json:"name,omitempty"`
+		Skip string  `json:"-"`
+	}
+
+	var vs []testModel
+
+	if err := m.List(context.Background(), &vs, args{Name: strPtr("b"), Skip: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "b" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+}
+
+func TestListN(t *testing.T) {
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c")
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	n, err := m.ListN(context.Background(), &vs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 3 || n != len(vs) {
+		t.Fatalf("count %d for %d rows", n, len(vs))
+	}
+}
+
+func TestJSONPathFilter(t *testing.T) {
+	type args struct {
+		Status *string `field:"data->>status"`
+	}
+
+	pg, log := testPostgres(t)
+	m := testModels(t, pg)
+
+	var vs []testModel
+
+	m.List(context.Background(), &vs, args{Status: strPtr("o'k")})
+	assertContains(t, log.last(), `"test_model"."data"->>'status' = 'o''k'`)
+
+	query, params, err := m.Compile(m.Select((*testModel)(nil)).Where("?TableAlias.?->>? = ?", bun.Ident("data"), "status", "ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, `"data"->>$1 = $2`)
+
+	if len(params) != 2 {
+		t.Fatalf("expected 2 params, got %v", params)
+	}
+
+	db, _ := testSQLite(t)
+	m = testModels(t, db)
+
+	if err := m.List(context.Background(), &vs, args{Status: strPtr("ok")}); err == nil {
+		t.Fatal("expected json path filter to require postgres")
+	}
+}
+
+type softModel struct {
+	bun.BaseModel `bun:"table:soft_models"`
+
+	ID      int64  `bun:"id,pk,autoincrement"`
+	Name    string `bun:"name"`
+	Deleted bool   `bun:"deleted"`
+}
+
+func (*softModel) QueryDefault(q *bun.SelectQuery) *bun.SelectQuery {
+	return q.Where("?TableAlias.deleted = false")
+}
+
+func TestWithTrashed(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*softModel)(nil))
+	insertRows(t, db, &softModel{Name: "a"}, &softModel{Name: "b", Deleted: true})
+	m := testModels(t, db)
+
+	var vs []softModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "a" {
+		t.Fatalf("deleted row listed: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, nil, WithTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("expected deleted row with WithTrashed: %+v", vs)
+	}
+
+	if n, err := m.Count(ctx, (*softModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if n, err := m.Count(ctx, (*softModel)(nil), nil, WithTrashed()); err != nil || n != 2 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.Get(ctx, &softModel{ID: 2}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected deleted row hidden, got %v", err)
+	}
+
+	if err := m.Get(ctx, &softModel{ID: 2}, WithTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		Name string `field:"name"`
+	}
+
+	if ok, err := m.Exists(ctx, (*softModel)(nil), args{Name: "b"}); err != nil || ok {
+		t.Fatalf("exists %v: %v", ok, err)
+	}
+
+	if ok, err := m.Exists(ctx, (*softModel)(nil), args{Name: "b"}, WithTrashed()); err != nil || !ok {
+		t.Fatalf("exists %v: %v", ok, err)
+	}
+}
+
+type timestampSoftModel struct {
+	bun.BaseModel `bun:"table:timestamp_soft_models"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	Name      string     `bun:"name"`
+	DeletedAt *time.Time `bun:"deleted_at"`
+}
+
+func TestOnlyTrashed(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*timestampSoftModel)(nil))
+	insertRows(t, db,
+		&timestampSoftModel{Name: "a"},
+		&timestampSoftModel{Name: "b", DeletedAt: &now},
+		&timestampSoftModel{Name: "c", DeletedAt: &now},
+		&timestampSoftModel{Name: "d"},
+	)
+	m := testModels(t, db, WithSoftDeleteColumn("deleted_at"))
+
+	var vs []timestampSoftModel
+
+	if err := m.List(ctx, &vs, nil, OnlyTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].Name != "b" || vs[1].Name != "c" {
+		t.Fatalf("expected only deleted rows: %+v", vs)
+	}
+
+	if err := testModels(t, db).List(ctx, &vs, nil, OnlyTrashed()); !errors.Is(err, ErrNoSoftDelete) {
+		t.Fatalf("expected ErrNoSoftDelete, got %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*timestampSoftModel)(nil), (*softModel)(nil))
+	insertRows(t, db, &timestampSoftModel{Name: "a"}, &softModel{Name: "a"})
+
+	m := testModels(t, db, WithSoftDeleteColumn("deleted_at"))
+
+	v := &timestampSoftModel{ID: 1}
+
+	if err := m.Delete(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Get(ctx, &timestampSoftModel{ID: 1}); err == nil {
+		t.Fatal("expected deleted row hidden")
+	}
+
+	n, err := m.Restore(ctx, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 || v.DeletedAt != nil {
+		t.Fatalf("restored %d: %+v", n, v)
+	}
+
+	if err := m.Get(ctx, &timestampSoftModel{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	m = testModels(t, db, WithSoftDeleteColumn("deleted"))
+
+	if _, err := m.DeleteMany(ctx, (*softModel)(nil), []int64{1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Restore(ctx, &softModel{ID: 1}); err != nil || n != 1 {
+		t.Fatalf("restored %d: %v", n, err)
+	}
+
+	if _, err := m.Restore(ctx, &testModel{ID: 1}); !errors.Is(err, ErrNoSoftDelete) {
+		t.Fatalf("expected ErrNoSoftDelete, got %v", err)
+	}
+}
+
+func TestGetMany(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c", "d")
+	m := testModels(t, db)
+
+	var vs []*testModel
+
+	if err := m.GetMany(ctx, &vs, []int64{1, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 3 || vs[0].Name != "a" || vs[1].Name != "c" || vs[2].Name != "d" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	log.reset()
+
+	if err := m.GetMany(ctx, &vs, []int64{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if vs == nil || len(vs) != 0 {
+		t.Fatalf("expected empty rows: %+v", vs)
+	}
+
+	if len(log.queries) != 0 {
+		t.Fatalf("unexpected queries: %v", log.queries)
+	}
+}
+
+func TestListMap(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db,
+		&testModel{Name: "a", Email: "a@example.com"},
+		&testModel{Name: "b", Email: "b@example.com"},
+		&testModel{Name: "c", Email: "b@example.com"},
+	)
+	m := testModels(t, db)
+
+	byEmail := func(v *testModel) string { return v.Email }
+
+	vm, err := ListMap(ctx, m, nil, byEmail)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vm) != 2 || vm["a@example.com"].Name != "a" || vm["b@example.com"].Name != "c" {
+		t.Fatalf("unexpected map: %+v", vm)
+	}
+
+	if _, err := ListMap(ctx, m, nil, byEmail, UniqueKeys()); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected ErrDuplicateKey, got %v", err)
+	}
+}
+
+type tenant struct {
+	bun.BaseModel `bun:"table:tenants"`
+
+	ID  int64  `bun:"id,pk,autoincrement"`
+	Org string `bun:"org"`
+}
+
+type tenantModel struct {
+	bun.BaseModel `bun:"table:tenant_models"`
+
+	ID       int64 `bun:"id,pk,autoincrement"`
+	TenantID int64 `bun:"tenant_id"`
+}
+
+type orgKey struct{}
+
+func (*tenantModel) QueryDefaultContext(ctx context.Context, q *bun.SelectQuery) *bun.SelectQuery {
+	org, _ := ctx.Value(orgKey{}).(string)
+
+	tenants := q.DB().NewSelect().Model((*tenant)(nil)).Column("id").Where("org = ?", org)
+
+	return q.Where("tenant_id IN (?)", tenants)
+}
+
+func TestQueryDefaultContext(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*tenant)(nil), (*tenantModel)(nil))
+	insertRows(t, db,
+		&tenant{Org: "x"},
+		&tenant{Org: "y"},
+		&tenantModel{TenantID: 1},
+		&tenantModel{TenantID: 2},
+		&tenantModel{TenantID: 1},
+	)
+	m := testModels(t, db)
+
+	var vs []tenantModel
+
+	if err := m.List(context.WithValue(ctx, orgKey{}, "x"), &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].ID != 1 || vs[1].ID != 3 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	assertContains(t, log.last(), `IN (SELECT "tenant"."id" FROM "tenants" AS "tenant" WHERE (org = 'x'))`)
+}
+
+func TestExcludeColumns(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "a")
+	m := testModels(t, db)
+
+	if err := m.Create(ctx, &testModel{Name: "b", Status: "s"}, ExcludeColumns("status")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), "INSERT")
+	assertNotContains(t, log.last(), "status")
+
+	if err := m.Save(ctx, &testModel{ID: 1, Name: "c", Email: "new"}, "status"); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "c" || v.Email != "a@example.com" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if err := m.Upsert(ctx, &testModel{ID: 1, Name: "d", Status: "s"}, ExcludeColumns("status", "name")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, log.last(), "status")
+}
+
+func TestUpdateColumns(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Email: "a@example.com", Status: "s"})
+	m := testModels(t, db)
+
+	if err := m.Update(ctx, &testModel{ID: 1, Name: "b", Email: "ignored"}, "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, log.last(), "email")
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "b" || v.Email != "a@example.com" || v.Status != "s" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if err := m.Update(ctx, &testModel{ID: 1, Name: "c", Email: "c@example.com"}, "name", "email"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "c" || v.Email != "c@example.com" || v.Status != "s" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+}
+
+func TestStrictFilters(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db)
+	m := testModels(t, db, WithStrictFilters())
+
+	type typo struct {
+		Email string `field:"emial"`
+	}
+
+	var vs []testModel
+
+	log.reset()
+
+	if err := m.List(ctx, &vs, typo{Email: "x"}); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+
+	if len(log.queries) != 0 {
+		t.Fatalf("unexpected queries: %v", log.queries)
+	}
+
+	type valid struct {
+		Email string `field:"email"`
+	}
+
+	if err := m.List(ctx, &vs, valid{Email: "x"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Update(ctx, &testModel{ID: 1}, "emial"); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestWith(t *testing.T) {
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b")
+	m := testModels(t, db)
+
+	c := m.With(WithFilterTag("json"))
+
+	if c.DB() != m.DB() {
+		t.Fatal("expected clone to share the database")
+	}
+
+	type args struct {
+		Name *string `field:"name" json:"name"`
+	}
+
+	var vs []testModel
+
+	if err := c.List(context.Background(), &vs, args{Name: strPtr("a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 {
+		t.Fatalf("clone ignored its filter tag: %+v", vs)
+	}
+
+	type jsonArgs struct {
+		Name *string `json:"name"`
+	}
+
+	if err := m.List(context.Background(), &vs, jsonArgs{Name: strPtr("a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("clone changed the parent filter tag: %+v", vs)
+	}
+}
+
+func TestNotSlicePointer(t *testing.T) {
+	db, _ := testSQLite(t)
+	m := testModels(t, db)
+
+	var ints []int
+
+	if err := m.List(context.Background(), &ints, nil); !errors.Is(err, ErrNotSlicePointer) {
+		t.Fatalf("expected ErrNotSlicePointer, got %v", err)
+	}
+
+	if err := m.List(context.Background(), []testModel{}, nil); !errors.Is(err, ErrNotSlicePointer) {
+		t.Fatalf("expected ErrNotSlicePointer, got %v", err)
+	}
+
+	if err := m.List(context.Background(), &ints, nil); !strings.Contains(err.Error(), "pointer to slice expected") {
+		t.Fatalf("unexpected message: %v", err)
+	}
+}
+
+func TestScalar(t *testing.T) {
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModelWithMultipleTags)(nil))
+	insertRows(t, db,
+		&testModelWithMultipleTags{Status: "a", Version: 3},
+		&testModelWithMultipleTags{Status: "b", Version: 7},
+		&testModelWithMultipleTags{Status: "a", Version: 5},
+	)
+	m := testModels(t, db)
+
+	var max int64
+
+	if err := m.Scalar(context.Background(), (*testModelWithMultipleTags)(nil), &max, "MAX(version)", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if max != 7 {
+		t.Fatalf("unexpected max: %d", max)
+	}
+
+	if err := m.Scalar(context.Background(), (*testModelWithMultipleTags)(nil), &max, "MAX(version)", testQueryArgs{Status: strPtr("a")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if max != 5 {
+		t.Fatalf("unexpected filtered max: %d", max)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db, log := testSQLite(t)
+	m := testModels(t, db)
+
+	query, err := m.Explain(context.Background(), &[]testModel{}, testQueryArgs{Status: strPtr("a")}, OrderBy("name"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `SELECT "test_model"."id", "test_model"."name", "test_model"."email", "test_model"."status", "test_model"."version" FROM "test_models" AS "test_model" WHERE ("test_model".status = 'a') ORDER BY name`
+
+	if query != expected {
+		t.Fatalf("unexpected sql: %s", query)
+	}
+
+	if len(log.queries) != 0 {
+		t.Fatalf("explain ran queries: %v", log.queries)
+	}
+}
+
+func TestExplainPlan(t *testing.T) {
+	db, _ := testSQLite(t)
+	seedTestModels(t, db)
+	m := testModels(t, db)
+
+	plan, err := m.ExplainPlan(context.Background(), &[]testModel{}, testQueryArgs{Status: strPtr("a")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if plan == "" {
+		t.Fatal("expected a plan")
+	}
+
+	if _, err := m.ExplainPlan(context.Background(), &[]testModel{}, nil, Analyze()); err == nil {
+		t.Fatal("expected analyze to require postgres")
+	}
+}
+
+func TestCombinedArgs(t *testing.T) {
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db,
+		&testModel{Name: "a", Status: "x"},
+		&testModel{Name: "a", Status: "y"},
+		&testModel{Name: "b", Status: "y"},
+	)
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	args := []any{testQueryArgs{Name: strPtr("a")}, nil, testQueryArgs{Status: strPtr("y")}}
+
+	if err := m.List(context.Background(), &vs, args); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].ID != 2 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	assertContains(t, log.last(), `WHERE ("test_model".name = 'a') AND ("test_model".status = 'y')`)
+}
+
+func TestLikeFilters(t *testing.T) {
+	type args struct {
+		Name  *string `field:"name,ilike"`
+		Match *string `field:"name,like"`
+	}
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "Johnny", "bob")
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	if err := m.List(context.Background(), &vs, args{Name: strPtr("JOHN")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "Johnny" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	assertContains(t, log.last(), `LOWER("test_model".name) LIKE LOWER('%JOHN%')`)
+
+	if err := m.List(context.Background(), &vs, args{Match: strPtr("b%")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "bob" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	pg, log := testPostgres(t)
+	m = testModels(t, pg)
+
+	m.List(context.Background(), &vs, args{Name: strPtr("JOHN")})
+	assertContains(t, log.last(), `"test_model".name ILIKE '%JOHN%'`)
+}
+
+func TestListPage(t *testing.T) {
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c", "d", "e", "f", "g", "h", "i", "j")
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	total, err := m.ListPage(context.Background(), &vs, nil, Page{Limit: 3, Offset: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 10 || len(vs) != 3 || vs[0].ID != 4 {
+		t.Fatalf("total %d: %+v", total, vs)
+	}
+}
+
+type plainModel struct {
+	bun.BaseModel `bun:"table:plain_models"`
+
+	ID   int64  `bun:"id,pk"`
+	Name string `bun:"name"`
+}
+
+type pkModel struct {
+	bun.BaseModel `bun:"table:pk_models"`
+
+	ID int64 `bun:"id,pk"`
+}
+
+func TestEmptyUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*plainModel)(nil), (*pkModel)(nil))
+	m := testModels(t, db)
+
+	if err := m.Save(ctx, &plainModel{ID: 1, Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Save(ctx, &plainModel{ID: 1, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := m.Save(ctx, &pkModel{ID: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.With(WithEmptyUpdate(EmptyUpdateNothing)).Save(ctx, &plainModel{ID: 1, Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &plainModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "b" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if err := m.Upsert(ctx, &plainModel{ID: 1}, WithEmptyUpdate(EmptyUpdateError)); !errors.Is(err, ErrNoUpdateColumns) {
+		t.Fatalf("expected ErrNoUpdateColumns, got %v", err)
+	}
+}
+
+func TestIgnoreConflict(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db)
+	m := testModels(t, db)
+
+	if err := m.Create(ctx, &testModel{ID: 1, Name: "a"}, IgnoreConflict()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Create(ctx, &testModel{ID: 1, Name: "b"}, IgnoreConflict()); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.Create(ctx, &testModel{ID: 1, Name: "b"}); err == nil {
+		t.Fatal("expected conflict")
+	}
+}
+
+func TestTable(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db)
+
+	if _, err := db.NewCreateTable().Model((*testModel)(nil)).ModelTableExpr("other_models").Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testModels(t, db)
+	other := Table("other_models")
+
+	if err := m.Create(ctx, &testModel{Name: "a"}, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 0 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil, other); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.With(other).Update(ctx, &testModel{ID: 1, Name: "b"}, "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "b" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if err := m.Delete(ctx, v, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil, other); err != nil || n != 0 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.Get(ctx, v, Table("x; drop")); err == nil {
+		t.Fatal("expected invalid table")
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	db, _ := testSQLite(b)
+	seedTestModels(b, db, "a")
+	m := testModels(b, db)
+
+	ctx := context.Background()
+	t := reflect.TypeOf(testModel{})
+
+	run := func(b *testing.B, cached bool) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if !cached {
+				modelTagsCache.Delete(t)
+				updateColumnsCache.Delete(t)
+			}
+
+			if err := m.Save(ctx, &testModel{ID: 1, Name: "b"}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("uncached", func(b *testing.B) { run(b, false) })
+	b.Run("cached", func(b *testing.B) { run(b, true) })
+}
+
+func TestUpdateColumnsCache(t *testing.T) {
+	db, _ := testSQLite(t)
+	m := testModels(t, db)
+
+	typ := reflect.TypeOf(testModelWithMultipleTags{})
+
+	modelTagsCache.Delete(typ)
+	updateColumnsCache.Delete(typ)
+
+	uncached := m.updateColumns(&testModelWithMultipleTags{}, m.opts)
+	cached := m.updateColumns(&testModelWithMultipleTags{}, m.opts)
+
+	if !reflect.DeepEqual(uncached, []string{"name", "status"}) || !reflect.DeepEqual(cached, uncached) {
+		t.Fatalf("uncached %v, cached %v", uncached, cached)
+	}
+}
+
+func BenchmarkQueryArgs(b *testing.B) {
+	db, _ := testSQLite(b)
+	m := testModels(b, db)
+
+	args := testQueryArgs{Name: strPtr("a")}
+	key := filtersKey{t: reflect.TypeOf(args), tag: m.opts.filterTag}
+
+	run := func(b *testing.B, cached bool) {
+		b.ReportAllocs()
+
+		for i := 0; i < b.N; i++ {
+			if !cached {
+				filtersCache.Delete(key)
+			}
+
+			if err := m.queryArgs(m.Select((*testModel)(nil)), (*testModel)(nil), args, m.opts); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("uncached", func(b *testing.B) { run(b, false) })
+	b.Run("cached", func(b *testing.B) { run(b, true) })
+}
+
+func TestQueryArgsCache(t *testing.T) {
+	db, _ := testSQLite(t)
+	m := testModels(t, db)
+
+	args := testQueryArgs{Name: strPtr("a")}
+
+	render := func() string {
+		q := m.Select((*testModel)(nil))
+
+		if err := m.queryArgs(q, (*testModel)(nil), args, m.opts); err != nil {
+			t.Fatal(err)
+		}
+
+		return q.String()
+	}
+
+	filtersCache.Delete(filtersKey{t: reflect.TypeOf(args), tag: m.opts.filterTag})
+
+	uncached := render()
+
+	if cached := render(); cached != uncached {
+		t.Fatalf("uncached %s, cached %s", uncached, cached)
+	}
+
+	assertContains(t, uncached, `"test_model".name = 'a'`)
+	assertNotContains(t, uncached, ".status =")
+}
+
+type oddlyNamedModel struct {
+	bun.BaseModel `bun:"table:oddly*/named"`
+
+	ID int64 `bun:"id,pk,autoincrement"`
+}
+
+func TestQueryComment(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db)
+	m := testModels(t, db, WithQueryComment())
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), "/* op=List model=test_models */ ")
+
+	if err := m.Create(ctx, &testModel{Name: "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), "/* op=Create model=test_models */ ")
+
+	query, err := m.Explain(ctx, &[]oddlyNamedModel{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, "/* op=Explain model=oddlynamed */ ")
+	assertNotContains(t, query, "model=oddly*/named")
+}
+
+func TestPing(t *testing.T) {
+	db, _ := testSQLite(t)
+	m := testModels(t, db)
+
+	if err := m.Ping(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := m.Ping(ctx); err == nil {
+		t.Fatal("expected cancelled ping to fail")
+	}
+
+	db.Close()
+
+	if err := m.Ping(context.Background()); err == nil {
+		t.Fatal("expected ping to fail on a closed database")
+	}
+}
+
+func TestDBInTransaction(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db)
+	m := testModels(t, db)
+
+	rollback := errors.New("rollback")
+
+	err := m.Transaction(ctx, func(tm *Models) error {
+		if _, err := tm.DB().NewInsert().Model(&testModel{Name: "a"}).Exec(ctx); err != nil {
+			return err
+		}
+
+		if n, err := tm.Count(ctx, (*testModel)(nil), nil); err != nil || n != 1 {
+			t.Fatalf("count %d: %v", n, err)
+		}
+
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("expected rollback, got %v", err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 0 {
+		t.Fatalf("insert not rolled back: %d, %v", n, err)
+	}
+
+	if m.DB() != db {
+		t.Fatal("expected the database outside a transaction")
+	}
+}
+
+type stampedModel struct {
+	bun.BaseModel `bun:"table:stamped_models"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Name      string    `bun:"name"`
+	UpdatedAt time.Time `bun:"updated_at" model:"updated"`
+}
+
+func TestTouch(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour)
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*stampedModel)(nil))
+	insertRows(t, db, &stampedModel{Name: "a", UpdatedAt: old})
+	m := testModels(t, db)
+
+	v := &stampedModel{ID: 1, Name: "local"}
+
+	if err := m.Touch(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.UpdatedAt.IsZero() {
+		t.Fatal("expected timestamp set on the model")
+	}
+
+	g := &stampedModel{ID: 1}
+
+	if err := m.Get(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Name != "a" || !g.UpdatedAt.After(old) {
+		t.Fatalf("unexpected row: %+v", g)
+	}
+
+	if err := m.Touch(ctx, &stampedModel{ID: 5}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := m.Touch(ctx, &testModel{ID: 1}); !errors.Is(err, ErrNoUpdatedColumn) {
+		t.Fatalf("expected ErrNoUpdatedColumn, got %v", err)
+	}
+}
+
+func TestTouchMySQL(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testMySQL(t)
+	createTables(t, db, (*stampedModel)(nil))
+	m := testModels(t, db)
+
+	if err := m.Touch(ctx, &stampedModel{ID: 1}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	assertContains(t, log.last(), "SELECT EXISTS")
+}
+
+func TestIncrement(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "a")
+	m := testModels(t, db)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := m.Increment(ctx, &testModel{ID: 1}, "version", 2); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assertContains(t, log.last(), `SET "version" = "version" + 2`)
+
+	if err := m.Decrement(ctx, &testModel{ID: 1}, "version", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Version != 15 {
+		t.Fatalf("unexpected version: %d", v.Version)
+	}
+
+	if err := m.Increment(ctx, &testModel{ID: 1}, "name", 1); err == nil {
+		t.Fatal("expected numeric column error")
+	}
+
+	if err := m.Increment(ctx, &testModel{ID: 1}, "nope", 1); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+
+	if err := m.Increment(ctx, &testModel{ID: 9}, "version", 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	mysql, log := testMySQL(t)
+	seedTestModels(t, mysql)
+
+	if err := testModels(t, mysql).Increment(ctx, &testModel{ID: 1}, "version", 1); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	assertContains(t, log.last(), "SELECT EXISTS")
+}
+
+type taggedSoftModel struct {
+	bun.BaseModel `bun:"table:tagged_soft_models"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	Name      string     `bun:"name"`
+	DeletedAt *time.Time `bun:"deleted_at" model:"softdelete"`
+}
+
+type nativeSoftModel struct {
+	bun.BaseModel `bun:"table:native_soft_models"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Name      string    `bun:"name"`
+	DeletedAt time.Time `bun:"deleted_at,soft_delete,nullzero"`
+}
+
+func TestSoftDeleteTag(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*taggedSoftModel)(nil), (*nativeSoftModel)(nil))
+	m := testModels(t, db)
+
+	for _, name := range []string{"a", "b"} {
+		if err := m.Create(ctx, &taggedSoftModel{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Create(ctx, &nativeSoftModel{Name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v := &taggedSoftModel{ID: 1}
+
+	if err := m.Delete(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.DeletedAt == nil {
+		t.Fatal("expected deleted_at set on the model")
+	}
+
+	if n, err := m.Count(ctx, (*taggedSoftModel)(nil), nil, WithTrashed()); err != nil || n != 2 {
+		t.Fatalf("row hard deleted: %d, %v", n, err)
+	}
+
+	var vs []taggedSoftModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].ID != 2 {
+		t.Fatalf("deleted row listed: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, nil, OnlyTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].ID != 1 {
+		t.Fatalf("unexpected trashed rows: %+v", vs)
+	}
+
+	if err := m.Delete(ctx, &nativeSoftModel{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ns []nativeSoftModel
+
+	if err := m.List(ctx, &ns, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ns) != 1 || ns[0].ID != 2 {
+		t.Fatalf("deleted row listed: %+v", ns)
+	}
+
+	if err := m.List(ctx, &ns, nil, WithTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ns) != 2 {
+		t.Fatalf("expected deleted row with WithTrashed: %+v", ns)
+	}
+}
+
+func TestSoftDeleteColumn(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*timestampSoftModel)(nil))
+	insertRows(t, db, &timestampSoftModel{Name: "a"}, &timestampSoftModel{Name: "b", DeletedAt: &now})
+	m := testModels(t, db, WithSoftDeleteColumn("deleted_at"))
+
+	var vs []timestampSoftModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "a" {
+		t.Fatalf("deleted row listed: %+v", vs)
+	}
+
+	if n, err := m.Count(ctx, (*timestampSoftModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.Get(ctx, &timestampSoftModel{ID: 2}); err == nil {
+		t.Fatal("expected deleted row hidden")
+	}
+
+	if err := m.Select(&vs).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 {
+		t.Fatalf("deleted row selected: %+v", vs)
+	}
+}
+
+type sluggedModel struct {
+	bun.BaseModel `bun:"table:slugged_models"`
+
+	ID   int64  `bun:"id,pk,autoincrement"`
+	Name string `bun:"name"`
+	Slug string `bun:"slug,nullzero"`
+	Code string `bun:"code,nullzero"`
+}
+
+func TestReturning(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testPostgres(t)
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE slugged_models (id INTEGER PRIMARY KEY, name TEXT, slug TEXT DEFAULT 'generated', code TEXT DEFAULT 'c')`); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testModels(t, db)
+
+	v := &sluggedModel{ID: 1, Name: "a"}
+
+	if err := m.Create(ctx, v, Returning("name"), ExcludeColumns("slug", "code")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `RETURNING "id", "name", "slug", "code"`)
+
+	if v.Slug != "generated" || v.Code != "c" {
+		t.Fatalf("defaults not returned: %+v", v)
+	}
+
+	if err := m.Create(ctx, &sluggedModel{ID: 2}, Returning("nope")); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestFindOne(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Status: "x"}, &testModel{Name: "b", Status: "x"})
+	m := testModels(t, db)
+
+	var v testModel
+
+	if err := m.Find(ctx, &v, testQueryArgs{Status: strPtr("x")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.FindOne(ctx, &v, testQueryArgs{Status: strPtr("x")}); !errors.Is(err, ErrMultipleResults) {
+		t.Fatalf("expected ErrMultipleResults, got %v", err)
+	}
+
+	v = testModel{}
+
+	if err := m.FindOne(ctx, &v, testQueryArgs{Name: strPtr("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "b" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if err := m.FindOne(ctx, &v, testQueryArgs{Name: strPtr("z")}); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestListMore(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c", "d", "e")
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	more, err := m.ListMore(ctx, &vs, nil, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !more || len(vs) != 3 {
+		t.Fatalf("more %v: %+v", more, vs)
+	}
+
+	more, err = m.ListMore(ctx, &vs, nil, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if more || len(vs) != 5 {
+		t.Fatalf("more %v: %+v", more, vs)
+	}
+
+	for _, limit := range []int{0, -1} {
+		if _, err := m.ListMore(ctx, &vs, nil, limit); err == nil {
+			t.Fatalf("limit %d: expected error", limit)
+		}
+	}
+}
+
+func TestDefaultOrder(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "b", "c", "a")
+	m := testModels(t, db, WithDefaultOrder("name ASC"))
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 3 || vs[0].Name != "a" || vs[1].Name != "b" || vs[2].Name != "c" {
+		t.Fatalf("unexpected order: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, nil, OrderBy("id DESC")); err != nil {
+		t.Fatal(err)
+	}
+
+	if vs[0].ID != 3 || vs[2].ID != 1 {
+		t.Fatalf("unexpected order: %+v", vs)
+	}
+
+	assertContains(t, log.last(), "ORDER BY id DESC")
+	assertNotContains(t, log.last(), "name ASC")
+
+	if err := m.List(ctx, &vs, nil, OrderBy("id; DROP TABLE test_models")); err == nil {
+		t.Fatal("expected invalid order")
+	}
+
+	query, err := m.Explain(ctx, &vs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, "ORDER BY name ASC")
+}
+
+func TestWhereOr(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db,
+		&testModel{Name: "a", Status: "active"},
+		&testModel{Name: "a", Status: "archived"},
+		&testModel{Name: "a", Status: "gone"},
+		&testModel{Name: "b", Status: "active"},
+	)
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, testQueryArgs{Name: strPtr("a")}, Where("status = ?", "active"), WhereOr("status = ?", "archived")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].ID != 1 || vs[1].ID != 2 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	assertContains(t, log.last(), `WHERE ((status = 'active') OR (status = 'archived')) AND ("test_model".name = 'a')`)
+}
+
+type refModel struct {
+	bun.BaseModel `bun:"table:ref_models"`
+
+	ID      int64  `bun:"id,pk,autoincrement"`
+	ModelID int64  `bun:"model_id"`
+	Kind    string `bun:"kind"`
+}
+
+func TestWhereInSubquery(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c", "d")
+	createTables(t, db, (*refModel)(nil))
+	insertRows(t, db,
+		&refModel{ModelID: 2, Kind: "x"},
+		&refModel{ModelID: 4, Kind: "x"},
+		&refModel{ModelID: 3, Kind: "y"},
+	)
+	m := testModels(t, db)
+
+	sub := m.Select((*refModel)(nil)).Column("model_id").Where("kind = ?", "x")
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil, WhereInSubquery("id", sub)); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].ID != 2 || vs[1].ID != 4 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+}
+
+func TestEach(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c", "d", "e")
+	m := testModels(t, db)
+
+	var names []string
+
+	err := m.Each(ctx, (*testModel)(nil), nil, func(row any) error {
+		names = append(names, row.(*testModel).Name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(names, "") != "abcde" {
+		t.Fatalf("unexpected rows: %v", names)
+	}
+
+	stop := errors.New("stop")
+	n := 0
+
+	err = m.Each(ctx, (*testModel)(nil), nil, func(row any) error {
+		if n++; n == 2 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || n != 2 {
+		t.Fatalf("expected stop after 2 rows, got %v after %d", err, n)
+	}
+}
+
+func TestEachBatch(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db)
+
+	for i := 0; i < 25; i++ {
+		insertRows(t, db, &testModel{Name: "a"})
+	}
+
+	m := testModels(t, db)
+
+	var sizes []int
+	var vs []*testModel
+
+	err := m.EachBatch(ctx, &vs, nil, 10, func(batch any) error {
+		sizes = append(sizes, len(batch.([]*testModel)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(sizes) != "[10 10 5]" {
+		t.Fatalf("unexpected batches: %v", sizes)
+	}
+
+	assertContains(t, log.last(), `WHERE ("test_model"."id" > 20) ORDER BY "test_model"."id" ASC LIMIT 10`)
+
+	for _, size := range []int{0, -1} {
+		if err := m.EachBatch(ctx, &vs, nil, size, func(any) error { return nil }); err == nil {
+			t.Fatalf("expected batch size %d to fail", size)
+		}
+	}
+}
+
+func TestCriteria(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+
+	for i := 0; i < 5; i++ {
+		insertRows(t, db, &testModel{Name: "a", Status: []string{"x", "y"}[i%2], Version: int64(i)})
+	}
+
+	m := testModels(t, db, WithStrictFilters())
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, NewCriteria().Eq("status", "x")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `WHERE ("test_model"."status" = 'x')`)
+
+	if len(vs) != 3 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, NewCriteria().Gt("version", 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `WHERE ("test_model"."version" > 2)`)
+
+	if len(vs) != 2 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, NewCriteria().In("id", []int64{1, 3, 5})); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `WHERE ("test_model"."id" IN (1, 3, 5))`)
+
+	if len(vs) != 3 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, NewCriteria().Eq("status", "x").Gt("version", 0).In("id", []int64{1, 3, 5})); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `WHERE ("test_model"."status" = 'x') AND ("test_model"."version" > 0) AND ("test_model"."id" IN (1, 3, 5))`)
+
+	if len(vs) != 2 || vs[0].ID != 3 || vs[1].ID != 5 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, NewCriteria().Eq("nope", 1)); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+type nodeModel struct {
+	bun.BaseModel `bun:"table:node_models"`
+
+	ID       int64  `bun:"id,pk,autoincrement"`
+	ParentID *int64 `bun:"parent_id"`
+}
+
+func TestNullEqFilter(t *testing.T) {
+	type args struct {
+		ParentID *int64 `field:"parent_id,nulleq"`
+	}
+
+	ctx := context.Background()
+	one := int64(1)
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*nodeModel)(nil))
+	insertRows(t, db, &nodeModel{}, &nodeModel{ParentID: &one}, &nodeModel{ParentID: &one})
+	m := testModels(t, db)
+
+	var vs []nodeModel
+
+	if err := m.List(ctx, &vs, args{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].ID != 1 {
+		t.Fatalf("unexpected rows for NULL: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, args{ParentID: &one}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("unexpected rows for 1: %+v", vs)
+	}
+
+	pg, log := testPostgres(t)
+
+	testModels(t, pg).List(ctx, &vs, args{})
+	assertContains(t, log.last(), `"node_model".parent_id IS NOT DISTINCT FROM NULL`)
+}
+
+func TestCountDistinct(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModelWithMultipleTags)(nil))
+
+	for _, status := range []string{"a", "b", "a", "c"} {
+		insertRows(t, db, &testModelWithMultipleTags{Status: status})
+	}
+
+	m := testModels(t, db)
+
+	n, err := m.CountDistinct(ctx, (*testModelWithMultipleTags)(nil), "status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 3 {
+		t.Fatalf("unexpected count: %d", n)
+	}
+
+	if _, err := m.CountDistinct(ctx, (*testModelWithMultipleTags)(nil), "nope", nil); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestListRaw(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b")
+	m := testModels(t, db)
+
+	rows, err := m.ListRaw(ctx, "test_models", map[string]any{"name": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 1 {
+		t.Fatalf("unexpected rows: %v", rows)
+	}
+
+	for _, key := range []string{"id", "name", "email"} {
+		if _, ok := rows[0][key]; !ok {
+			t.Fatalf("missing %s: %v", key, rows[0])
+		}
+	}
+
+	if rows[0]["email"] != "b@example.com" {
+		t.Fatalf("unexpected row: %v", rows[0])
+	}
+
+	if _, err := m.ListRaw(ctx, "x; drop", nil); err == nil {
+		t.Fatal("expected invalid table")
+	}
+}
+
+type xmaxModel struct {
+	bun.BaseModel `bun:"table:xmax_models"`
+
+	ID   int64  `bun:"id,pk,autoincrement"`
+	Name string `bun:"name" model:"update"`
+}
+
+// xmaxCodedModel is upserted on its code, leaving the id to the database.
+type xmaxCodedModel struct {
+	bun.BaseModel `bun:"table:xmax_coded_models"`
+
+	ID   int64  `bun:"id,pk,autoincrement"`
+	Code string `bun:"code,unique"`
+	Name string `bun:"name" model:"update"`
+}
+
+// xmaxTimedModel is upserted on a time, which comes back from the database in
+// another location.
+type xmaxTimedModel struct {
+	bun.BaseModel `bun:"table:xmax_timed_models"`
+
+	ID   int64     `bun:"id,pk,autoincrement"`
+	At   time.Time `bun:"at,unique"`
+	Name string    `bun:"name" model:"update"`
+}
+
+// postgresDialect is SQLite reporting itself as Postgres, for code that only
+// checks for Postgres by name, as the Postgres dialect writes DEFAULT for
+// generated keys and SQLite does not accept it.
+type postgresDialect struct {
+	*sqlitedialect.Dialect
+}
+
+func (postgresDialect) Name() dialect.Name {
+	return dialect.PG
+}
+
+func TestSaveManyResult(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a")
+	m := testModels(t, db)
+
+	vs := []testModel{{ID: 1, Name: "A"}, {ID: 2, Name: "B"}}
+
+	if err := m.SaveMany(ctx, &vs); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []testModel
+
+	if err := m.List(ctx, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Name != "A" || got[1].Name != "B" {
+		t.Fatalf("unexpected rows: %+v", got)
+	}
+
+	if _, err := m.SaveManyResult(ctx, &vs); err == nil {
+		t.Fatal("expected save results to require postgres")
+	}
+
+	// xmax is a system column on Postgres; SQLite stands in with a generated
+	// column that is non-zero for rows the upsert updated
+	pg, log := testPostgres(t)
+
+	if _, err := pg.ExecContext(ctx, `CREATE TABLE xmax_models (id INTEGER PRIMARY KEY, name TEXT, xmax INTEGER GENERATED ALWAYS AS (CASE WHEN name LIKE 'updated%' THEN 1 ELSE 0 END))`); err != nil {
+		t.Fatal(err)
+	}
+
+	insertRows(t, pg, &xmaxModel{ID: 1, Name: "a"}, &xmaxModel{ID: 2, Name: "b"})
+
+	xs := []xmaxModel{{ID: 5, Name: "new"}, {ID: 2, Name: "updated"}, {ID: 6, Name: "new"}, {ID: 1, Name: "updated"}}
+
+	results, err := testModels(t, pg).SaveManyResult(ctx, &xs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `RETURNING xmax = 0, "id"`)
+
+	if fmt.Sprint(results) != fmt.Sprint([]SaveResult{SaveInserted, SaveUpdated, SaveInserted, SaveUpdated}) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	lite, _ := newTestDB(t, postgresDialect{sqlitedialect.New()})
+
+	if _, err := lite.ExecContext(ctx, `CREATE TABLE xmax_coded_models (id INTEGER PRIMARY KEY AUTOINCREMENT, code TEXT UNIQUE, name TEXT, xmax INTEGER GENERATED ALWAYS AS (CASE WHEN name LIKE 'updated%' THEN 1 ELSE 0 END))`); err != nil {
+		t.Fatal(err)
+	}
+
+	insertRows(t, lite, &xmaxCodedModel{Code: "a", Name: "a"})
+
+	cs := []xmaxCodedModel{{Code: "b", Name: "new"}, {Code: "a", Name: "updated"}}
+
+	results, err = testModels(t, lite, ConflictOn("code")).SaveManyResult(ctx, &cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(results) != fmt.Sprint([]SaveResult{SaveInserted, SaveUpdated}) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	if cs[0].ID != 2 || cs[1].ID != 1 {
+		t.Fatalf("returned keys not set: %+v", cs)
+	}
+
+	if _, err := lite.ExecContext(ctx, `CREATE TABLE xmax_timed_models (id INTEGER PRIMARY KEY AUTOINCREMENT, at TIMESTAMP UNIQUE, name TEXT, xmax INTEGER GENERATED ALWAYS AS (CASE WHEN name LIKE 'updated%' THEN 1 ELSE 0 END))`); err != nil {
+		t.Fatal(err)
+	}
+
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.FixedZone("EST", -5*3600))
+
+	insertRows(t, lite, &xmaxTimedModel{At: at, Name: "a"})
+
+	ts := []xmaxTimedModel{{At: at.Add(time.Hour), Name: "new"}, {At: at, Name: "updated"}}
+
+	results, err = testModels(t, lite, ConflictOn("at")).SaveManyResult(ctx, &ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(results) != fmt.Sprint([]SaveResult{SaveInserted, SaveUpdated}) {
+		t.Fatalf("unexpected results: %v", results)
+	}
+
+	if ts[0].ID != 2 || ts[1].ID != 1 {
+		t.Fatalf("returned keys not set: %+v", ts)
+	}
+}
+
+func TestWhereColumns(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Email: "b"}, &testModel{Name: "c", Email: "b"})
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil, WhereColumns("name", "<=", "email")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "a" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	assertContains(t, log.last(), `WHERE (("name" <= "email"))`)
+
+	if err := m.List(ctx, &vs, nil, WhereColumns("name", "; drop", "email")); err == nil {
+		t.Fatal("expected invalid operator")
+	}
+}
+
+func TestNoDefaults(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*softModel)(nil))
+	insertRows(t, db, &softModel{Name: "a"}, &softModel{Name: "b", Deleted: true})
+	m := testModels(t, db)
+
+	if err := m.Get(ctx, &softModel{ID: 2}); err == nil {
+		t.Fatal("expected deleted row hidden")
+	}
+
+	if err := m.Get(ctx, &softModel{ID: 2}, NoDefaults()); err != nil {
+		t.Fatal(err)
+	}
+
+	var vs []softModel
+
+	if err := m.With(NoDefaults()).Select(&vs).Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("expected deleted row with NoDefaults: %+v", vs)
+	}
+}
+
+// nullStatus is a nullable filter value leaving itself out of queries when
+// invalid.
+type nullStatus struct {
+	String string
+	Valid  bool
+}
+
+func (n nullStatus) Value() (driver.Value, error) {
+	if !n.Valid {
+		return nil, nil
+	}
+
+	return n.String, nil
+}
+
+func (n nullStatus) OmitFromQuery() bool {
+	return !n.Valid
+}
+
+type omittedStatus string
+
+func (s omittedStatus) OmitFromQuery() bool {
+	return s == ""
+}
+
+func TestFilterable(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Status: "x"}, &testModel{Name: "b", Status: "y"})
+	m := testModels(t, db)
+
+	type args struct {
+		Status nullStatus `field:"status"`
+	}
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, args{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("invalid value not omitted: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, args{Status: nullStatus{String: "x", Valid: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "a" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	type nullable struct {
+		Status *omittedStatus `field:"status,nulleq"`
+	}
+
+	query, err := m.Explain(ctx, (*testModel)(nil), nullable{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, "IS NULL")
+
+	empty := omittedStatus("")
+
+	if query, err = m.Explain(ctx, (*testModel)(nil), nullable{Status: &empty}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, query, "WHERE")
+}
+
+type authorModel struct {
+	bun.BaseModel `bun:"table:author_models"`
+
+	ID      int64         `bun:"id,pk,autoincrement"`
+	Name    string        `bun:"name"`
+	Profile *profileModel `bun:"rel:has-one,join:id=author_id"`
+	Books   []bookModel   `bun:"rel:has-many,join:id=author_id"`
+}
+
+type profileModel struct {
+	bun.BaseModel `bun:"table:profile_models"`
+
+	ID       int64  `bun:"id,pk,autoincrement"`
+	AuthorID int64  `bun:"author_id"`
+	Bio      string `bun:"bio"`
+}
+
+type bookModel struct {
+	bun.BaseModel `bun:"table:book_models"`
+
+	ID       int64        `bun:"id,pk,autoincrement"`
+	AuthorID int64        `bun:"author_id"`
+	Title    string       `bun:"title"`
+	Deleted  bool         `bun:"deleted"`
+	Author   *authorModel `bun:"rel:belongs-to,join:author_id=id"`
+}
+
+func seedAuthors(t *testing.T, db *bun.DB) {
+	t.Helper()
+
+	createTables(t, db, (*authorModel)(nil), (*profileModel)(nil), (*bookModel)(nil))
+	insertRows(t, db,
+		&authorModel{Name: "a"},
+		&profileModel{AuthorID: 1, Bio: "bio"},
+		&bookModel{AuthorID: 1, Title: "y"},
+		&bookModel{AuthorID: 1, Title: "x"},
+		&bookModel{AuthorID: 1, Title: "z", Deleted: true},
+	)
+}
+
+func TestAllRelations(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedAuthors(t, db)
+	m := testModels(t, db)
+
+	a := &authorModel{ID: 1}
+
+	if err := m.Get(ctx, a, AllRelations()); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.Profile == nil || a.Profile.Bio != "bio" || len(a.Books) != 3 {
+		t.Fatalf("relations not loaded: %+v", a)
+	}
+
+	b := &authorModel{ID: 1}
+
+	if err := m.Get(ctx, b); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Profile != nil || b.Books != nil {
+		t.Fatalf("relations loaded without AllRelations: %+v", b)
+	}
+}
+
+func TestExistsPK(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*softModel)(nil))
+	insertRows(t, db, &softModel{Name: "a"}, &softModel{Name: "b", Deleted: true})
+	m := testModels(t, db)
+
+	for id, expected := range map[int64]bool{1: true, 2: false, 3: false} {
+		ok, err := m.ExistsPK(ctx, &softModel{ID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if ok != expected {
+			t.Fatalf("exists %d: %v", id, ok)
+		}
+	}
+}
+
+func TestUpdateColumnsMap(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Email: "e", Status: "s", Version: 1})
+	m := testModels(t, db)
+
+	n, err := m.UpdateColumns(ctx, &testModel{ID: 1}, map[string]any{"name": "b", "status": "t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("unexpected rows affected: %d", n)
+	}
+
+	assertContains(t, log.last(), `SET "name" = 'b', "status" = 't'`)
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "b" || v.Status != "t" || v.Email != "e" || v.Version != 1 {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if _, err := m.UpdateColumns(ctx, &testModel{ID: 1}, map[string]any{"nope": 1}); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+}
+
+func TestModelTagsEmpty(t *testing.T) {
+	type tagged struct {
+		Blank    string `model:""`
+		Trailing string `model:"update,"`
+		Leading  string `model:",unique"`
+		Spaced   string `model:" , "`
+	}
+
+	tags := modelTags(tagged{})
+
+	want := map[string]map[string]bool{
+		"Trailing": {"update": true},
+		"Leading":  {"unique": true},
+	}
+
+	if !reflect.DeepEqual(tags, want) {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+}
+
+func TestSelectInto(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedAuthors(t, db)
+	m := testModels(t, db)
+
+	var rows []struct {
+		Title  string `bun:"title"`
+		Author string `bun:"author"`
+	}
+
+	q := m.Select((*bookModel)(nil)).
+		ColumnExpr("book_model.title, a.name AS author").
+		Join("JOIN author_models AS a ON a.id = book_model.author_id").
+		Order("title")
+
+	if err := m.SelectInto(ctx, q, &rows); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 3 || rows[0].Title != "x" || rows[0].Author != "a" {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+}
+
+type auditedModel struct {
+	bun.BaseModel `bun:"table:audited_models"`
+
+	ID        int64  `bun:"id,pk,autoincrement"`
+	Name      string `bun:"name" model:"update"`
+	CreatedBy string `bun:"created_by"`
+	UpdatedBy string `bun:"updated_by"`
+}
+
+type userKey struct{}
+
+func TestAuditColumns(t *testing.T) {
+	ctx := context.Background()
+
+	as := func(user string) context.Context {
+		return context.WithValue(ctx, userKey{}, user)
+	}
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*auditedModel)(nil))
+	m := testModels(t, db, WithAuditColumns(func(ctx context.Context) (any, any, bool) {
+		user, ok := ctx.Value(userKey{}).(string)
+		return user, user, ok
+	}))
+
+	v := &auditedModel{Name: "a"}
+
+	if err := m.Create(as("alice"), v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.CreatedBy != "alice" || v.UpdatedBy != "alice" {
+		t.Fatalf("audit columns not set: %+v", v)
+	}
+
+	v.Name = "b"
+
+	if err := m.Update(as("bob"), v, "name"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Save(as("carol"), &auditedModel{ID: 1, Name: "c"}); err != nil {
+		t.Fatal(err)
+	}
+
+	g := &auditedModel{ID: 1}
+
+	if err := m.Get(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Name != "c" || g.CreatedBy != "alice" || g.UpdatedBy != "carol" {
+		t.Fatalf("unexpected audit columns: %+v", g)
+	}
+
+	w := &auditedModel{Name: "x"}
+
+	if err := m.Create(ctx, w); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.CreatedBy != "" || w.UpdatedBy != "" {
+		t.Fatalf("audit columns set without a user: %+v", w)
+	}
+}
+
+func TestSkipScanErrors(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a")
+
+	if _, err := db.ExecContext(ctx, `INSERT INTO test_models (name, email, status, version) VALUES ('b', '', '', 'notanint')`); err != nil {
+		t.Fatal(err)
+	}
+
+	insertRows(t, db, &testModel{Name: "c"})
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil); err == nil {
+		t.Fatal("expected scan failure")
+	}
+
+	var errs []error
+
+	if err := m.List(ctx, &vs, nil, SkipScanErrors(func(err error) { errs = append(errs, err) })); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].Name != "a" || vs[1].Name != "c" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if len(errs) != 1 {
+		t.Fatalf("expected one scan error, got %v", errs)
+	}
+}
+
+func TestFindValue(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b")
+	m := testModels(t, db)
+
+	v, err := FindValue[testModel](ctx, m, testQueryArgs{Name: strPtr("b")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.ID != 2 || v.Name != "b" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if _, err := FindValue[testModel](ctx, m, testQueryArgs{Name: strPtr("z")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFilterOrder(t *testing.T) {
+	ctx := context.Background()
+
+	type ordered struct {
+		Status *string `field:"status"`
+		Name   *string `field:"name" order:"desc"`
+	}
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db,
+		&testModel{Name: "b", Status: "x"},
+		&testModel{Name: "c", Status: "x"},
+		&testModel{Name: "a", Status: "x"},
+		&testModel{Name: "d", Status: "y"},
+	)
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, ordered{Status: strPtr("x")}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `ORDER BY "name" DESC`)
+
+	if len(vs) != 3 || vs[0].Name != "c" || vs[2].Name != "a" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	type lowered struct {
+		Name *string `field:"LOWER(name),expr" order:"asc nulls last"`
+	}
+
+	insertRows(t, db, &testModel{Name: "B"})
+
+	if err := m.List(ctx, &vs, lowered{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `ORDER BY LOWER("name") ASC NULLS LAST`)
+
+	if len(vs) != 5 || vs[0].Name != "a" || vs[4].Name != "d" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	mdb, mlog := testMySQL(t)
+	createTables(t, mdb, (*testModel)(nil))
+
+	if err := testModels(t, mdb).List(ctx, &vs, lowered{}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, mlog.last(), `ORDER BY LOWER("name") IS NULL ASC, LOWER("name") ASC`)
+
+	type pathed struct {
+		Status *string `field:"data->>status" order:"desc"`
+	}
+
+	if err := m.List(ctx, &vs, pathed{}); err == nil {
+		t.Fatal("expected json path order to require postgres")
+	}
+
+	pg, _ := testPostgres(t)
+
+	query, err := testModels(t, pg).Explain(ctx, (*testModel)(nil), pathed{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, `ORDER BY "data"->>'status' DESC`)
+
+	type invalid struct {
+		Name *string `field:"SUBSTR(name),expr" order:"asc"`
+	}
+
+	if err := m.List(ctx, &vs, invalid{}); err == nil {
+		t.Fatal("expected invalid order expression to fail")
+	}
+}
+
+func TestConflictUpdateWhere(t *testing.T) {
+	ctx := context.Background()
+	newer := ConflictUpdateWhere("EXCLUDED.version > ?TableAlias.version")
+
+	db, log := testPostgres(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{ID: 1, Name: "new", Version: 5})
+	m := testModels(t, db)
+
+	if err := m.Upsert(ctx, &testModel{ID: 1, Name: "old", Version: 3}, newer); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `WHERE (EXCLUDED.version > "test_model".version)`)
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "new" {
+		t.Fatalf("older row overwrote a newer one: %+v", v)
+	}
+
+	if err := m.With(newer).Save(ctx, &testModel{ID: 1, Name: "newer", Version: 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "newer" {
+		t.Fatalf("newer row not saved: %+v", v)
+	}
+
+	mdb, _ := testMySQL(t)
+
+	if err := testModels(t, mdb).Upsert(ctx, &testModel{ID: 1}, newer); err == nil {
+		t.Fatal("expected MySQL to fail")
+	}
+}
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now()
+	old, recent := start.Add(-48*time.Hour), start.Add(-time.Hour)
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*taggedSoftModel)(nil), (*testModel)(nil))
+	insertRows(t, db,
+		&taggedSoftModel{Name: "old", DeletedAt: &old},
+		&taggedSoftModel{Name: "recent", DeletedAt: &recent},
+		&taggedSoftModel{Name: "alive"},
+	)
+	m := testModels(t, db)
+
+	v := &taggedSoftModel{ID: 3}
+
+	if err := m.Delete(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.DeletedAt == nil || v.DeletedAt.Before(start) {
+		t.Fatalf("deleted_at not set to now: %+v", v)
+	}
+
+	n, err := m.Purge(ctx, (*taggedSoftModel)(nil), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("expected 1 purged row, got %d", n)
+	}
+
+	var vs []taggedSoftModel
+
+	if err := m.List(ctx, &vs, nil, WithTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].Name != "recent" || vs[1].Name != "alive" {
+		t.Fatalf("unexpected rows after purge: %+v", vs)
+	}
+
+	if _, err := m.Purge(ctx, (*testModel)(nil), time.Hour); !errors.Is(err, ErrNoSoftDelete) {
+		t.Fatalf("expected ErrNoSoftDelete, got %v", err)
+	}
+}
+
+func TestModelNotRegistered(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	m := testModels(t, db)
+
+	n := 1
+
+	for _, v := range []any{&n, &struct{}{}} {
+		if err := m.Save(ctx, v); !errors.Is(err, ErrModelNotRegistered) {
+			t.Fatalf("expected ErrModelNotRegistered for %T, got %v", v, err)
+		}
+	}
+
+	if q := log.last(); q != "" {
+		t.Fatalf("unexpected query: %s", q)
+	}
+}
+
+type membershipModel struct {
+	bun.BaseModel `bun:"table:membership_models"`
+
+	GroupID  int64 `bun:"group_id,pk"`
+	MemberID int64 `bun:"member_id,pk"`
+}
+
+func TestRegister(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	m := testModels(t, db)
+	m.Register((*membershipModel)(nil))
+	createTables(t, db, (*membershipModel)(nil))
+
+	if err := m.Create(ctx, &membershipModel{GroupID: 1, MemberID: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*membershipModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+}
+
+func TestSavepoint(t *testing.T) {
+	ctx := context.Background()
+	boom := errors.New("boom")
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	m := testModels(t, db)
+
+	err := m.Transaction(ctx, func(tm *Models) error {
+		if err := tm.Create(ctx, &testModel{Name: "outer"}); err != nil {
+			return err
+		}
+
+		err := tm.Savepoint(ctx, "attempt", func(sm *Models) error {
+			if err := sm.Create(ctx, &testModel{Name: "inner"}); err != nil {
+				return err
+			}
+			return boom
+		})
+		if err != boom {
+			t.Fatalf("expected boom, got %v", err)
+		}
+
+		return tm.Savepoint(ctx, "kept", func(sm *Models) error {
+			return sm.Create(ctx, &testModel{Name: "kept"})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].Name != "outer" || vs[1].Name != "kept" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.Savepoint(ctx, "outside", func(*Models) error { return nil }); err == nil {
+		t.Fatal("expected savepoint outside a transaction to fail")
+	}
+}
+
+func TestCreateGraph(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*authorModel)(nil))
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE book_models (id INTEGER PRIMARY KEY AUTOINCREMENT, author_id INTEGER NOT NULL, title TEXT NOT NULL UNIQUE, deleted BOOLEAN NOT NULL DEFAULT false)`); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testModels(t, db)
+
+	a := &authorModel{Name: "a"}
+	b := &bookModel{}
+
+	err := m.CreateGraph(ctx, a, func() any {
+		b.AuthorID = a.ID
+		b.Title = "x"
+		return b
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a.ID == 0 || b.ID == 0 || b.AuthorID != a.ID {
+		t.Fatalf("child does not reference parent: %+v %+v", a, b)
+	}
+
+	if err := m.CreateGraph(ctx, &authorModel{Name: "b"}, &bookModel{AuthorID: 2, Title: "x"}); err == nil {
+		t.Fatal("expected duplicate title to fail")
+	}
+
+	if n, err := m.Count(ctx, (*authorModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("parent of failed graph kept: %d, %v", n, err)
+	}
+}
+
+type flagModel struct {
+	bun.BaseModel `bun:"table:flag_models"`
+
+	ID     int64 `bun:"id,pk,autoincrement"`
+	Active bool  `bun:"active,nullzero,notnull,default:true"`
+}
+
+func TestForceZero(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*flagModel)(nil))
+	m := testModels(t, db)
+
+	if _, err := db.ExecContext(ctx, "INSERT INTO flag_models DEFAULT VALUES"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Create(ctx, &flagModel{}, ForceZero("active")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `"active"`)
+
+	var vs []flagModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || !vs[0].Active || vs[1].Active {
+		t.Fatalf("expected default then explicit false: %+v", vs)
+	}
+
+	if err := m.With(ForceZero("active")).Save(ctx, &flagModel{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &flagModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Active {
+		t.Fatalf("expected false saved: %+v", v)
+	}
+}
+
+func TestSelectWith(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedAuthors(t, db)
+	m := testModels(t, db)
+
+	var vs []bookModel
+
+	q := m.SelectWith(&vs, OrderBy("title DESC"), Relation("Author"), Where("title <> ?", "z"))
+
+	if err := q.Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), "ORDER BY title DESC")
+
+	if len(vs) != 2 || vs[0].Title != "y" || vs[0].Author == nil {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+}
+
+func TestQualifiedFilter(t *testing.T) {
+	ctx := context.Background()
+
+	type byAuthor struct {
+		Author *string `field:"author.name"`
+	}
+
+	type byMissing struct {
+		Author *string `field:"author.nope"`
+	}
+
+	db, log := testSQLite(t)
+	seedAuthors(t, db)
+	insertRows(t, db, &authorModel{Name: "b"}, &bookModel{AuthorID: 2, Title: "w"})
+	m := testModels(t, db, WithStrictFilters())
+
+	var vs []bookModel
+
+	if err := m.List(ctx, &vs, byAuthor{Author: strPtr("b")}, Relation("Author")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `"author"."name" = 'b'`)
+
+	if len(vs) != 1 || vs[0].Title != "w" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, byMissing{Author: strPtr("b")}, Relation("Author")); err == nil {
+		t.Fatal("expected unknown relation column to fail")
+	}
+}
+
+type ownerModel struct {
+	bun.BaseModel `bun:"table:owner_models"`
+
+	ID      int64  `bun:"id,pk,autoincrement"`
+	Status  string `bun:"status"`
+	Deleted bool   `bun:"deleted" model:"softdelete"`
+}
+
+type petModel struct {
+	bun.BaseModel `bun:"table:pet_models"`
+
+	ID      int64       `bun:"id,pk,autoincrement"`
+	OwnerID int64       `bun:"owner_id"`
+	Status  string      `bun:"status"`
+	Deleted bool        `bun:"deleted" model:"softdelete"`
+	Owner   *ownerModel `bun:"rel:belongs-to,join:owner_id=id"`
+}
+
+func TestSoftDeleteJoin(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*ownerModel)(nil), (*petModel)(nil))
+	insertRows(t, db,
+		&ownerModel{Status: "x"},
+		&petModel{OwnerID: 1, Status: "x"},
+		&petModel{OwnerID: 1, Status: "x", Deleted: true},
+	)
+	m := testModels(t, db)
+
+	var vs []petModel
+
+	if err := m.List(ctx, &vs, testQueryArgs{Status: strPtr("x")}, Relation("Owner")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].ID != 1 || vs[0].Owner == nil {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if n, err := m.Count(ctx, (*petModel)(nil), NewCriteria().Eq("status", "x"), Relation("Owner")); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	ctx := context.Background()
+	tenant := Schema("tenant_42")
+
+	db, log := testSQLite(t)
+
+	if _, err := db.ExecContext(ctx, "ATTACH DATABASE ':memory:' AS tenant_42"); err != nil {
+		t.Fatal(err)
+	}
+
+	createTables(t, db, (*testModel)(nil))
+
+	if _, err := db.NewCreateTable().Model((*testModel)(nil)).ModelTableExpr("tenant_42.test_models").Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testModels(t, db)
+
+	if err := m.Create(ctx, &testModel{Name: "a"}, tenant); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `"tenant_42"."test_models"`)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil, tenant); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 {
+		t.Fatalf("expected the tenant row: %+v", vs)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 0 {
+		t.Fatalf("row written outside the schema: %d, %v", n, err)
+	}
+
+	if err := m.With(tenant).Update(ctx, &testModel{ID: 1, Name: "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Delete(ctx, &testModel{ID: 1}, tenant); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil, tenant); err != nil || n != 0 {
+		t.Fatalf("row not deleted from the schema: %d, %v", n, err)
+	}
+
+	if err := m.List(ctx, &vs, nil, Schema("x;y")); err == nil {
+		t.Fatal("expected invalid schema to fail")
+	}
+}
+
+func TestSoftDeleteWhere(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*ownerModel)(nil), (*testModel)(nil))
+	insertRows(t, db, &ownerModel{Status: "old"}, &ownerModel{Status: "old"}, &ownerModel{Status: "new"})
+	m := testModels(t, db)
+
+	for _, args := range []any{nil, testQueryArgs{}} {
+		if _, err := m.SoftDeleteWhere(ctx, (*ownerModel)(nil), args); !errors.Is(err, ErrNoFilters) {
+			t.Fatalf("expected ErrNoFilters for %#v, got %v", args, err)
+		}
+	}
+
+	n, err := m.SoftDeleteWhere(ctx, (*ownerModel)(nil), testQueryArgs{Status: strPtr("old")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+
+	var vs []ownerModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Status != "new" {
+		t.Fatalf("deleted rows listed: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, nil, WithTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 3 {
+		t.Fatalf("expected deleted rows with WithTrashed: %+v", vs)
+	}
+
+	if n, err := m.SoftDeleteWhere(ctx, (*ownerModel)(nil), All); err != nil || n != 1 {
+		t.Fatalf("all: %d, %v", n, err)
+	}
+
+	if _, err := m.SoftDeleteWhere(ctx, (*testModel)(nil), All); !errors.Is(err, ErrNoSoftDelete) {
+		t.Fatalf("expected ErrNoSoftDelete, got %v", err)
+	}
+
+	mdb, log := testMySQL(t)
+	createTables(t, mdb, (*ownerModel)(nil))
+	insertRows(t, mdb, &ownerModel{Status: "old"}, &ownerModel{Status: "new"})
+
+	if n, err := testModels(t, mdb).SoftDeleteWhere(ctx, (*ownerModel)(nil), testQueryArgs{Status: strPtr("old")}); err != nil || n != 1 {
+		t.Fatalf("mysql: %d, %v", n, err)
+	}
+
+	assertContains(t, log.last(), `) AS "matching"`)
+}
+
+func TestFullText(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testPostgres(t)
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	explained, err := m.Explain(ctx, &vs, nil, FullText("search", "big dog"), FullTextRank("search", "big dog"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, explained, `"test_model"."search" @@ plainto_tsquery('big dog')`, "ORDER BY ts_rank(")
+
+	explained, err = m.Explain(ctx, &vs, nil, FullText("search", "it's"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, explained, `plainto_tsquery('it''s')`)
+
+	sdb, _ := testSQLite(t)
+	m = testModels(t, sdb)
+
+	if err := m.List(ctx, &vs, nil, FullText("search", "x")); err == nil || !strings.Contains(err.Error(), "requires postgres") {
+		t.Fatalf("expected requires postgres, got %v", err)
+	}
+
+	if err := m.List(ctx, &vs, nil, FullTextRank("search", "x")); err == nil {
+		t.Fatal("expected FullTextRank to fail on SQLite")
+	}
+}
+
+func TestWithinDistance(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testPostgres(t)
+	m := testModels(t, db)
+
+	var vs []testModel
+
+	explained, err := m.Explain(ctx, &vs, nil, WithinDistance("location", 40.7, -74.0, 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, explained, `ST_DWithin("test_model"."location", ST_MakePoint(-74, 40.7)::geography, 500)`)
+
+	sdb, _ := testSQLite(t)
+
+	if err := testModels(t, sdb).List(ctx, &vs, nil, WithinDistance("location", 1, 2, 3)); err == nil {
+		t.Fatal("expected WithinDistance to fail on SQLite")
+	}
+}
+
+func TestCompile(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a?b", Status: "on"}, &testModel{Name: "c", Status: "off"})
+	m := testModels(t, db)
+
+	q := m.SelectWith((*testModel)(nil), Where("name = ?", "a?b"), Where("status IN (?)", bun.In([]string{"on", "x"})))
+
+	query, args, err := m.Compile(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(args) != 3 || strings.Count(query, "?") != len(args) {
+		t.Fatalf("placeholders do not match args: %s %v", query, args)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	n := 0
+
+	for rows.Next() {
+		n++
+	}
+
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("expected 1 row, got %d", n)
+	}
+
+	pdb, _ := testPostgres(t)
+	m = testModels(t, pdb)
+
+	query, args, err = m.Compile(m.SelectWith((*testModel)(nil), Where("name = ? AND email = ?", "a", "b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, "$1", "$2")
+
+	if len(args) != 2 {
+		t.Fatalf("unexpected args: %v", args)
+	}
+
+	query, args, err = m.Compile(m.SelectWith((*testModel)(nil), FullText("search", "big dog")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, "@@ plainto_tsquery($1)")
+
+	if len(args) != 1 || args[0] != "big dog" {
+		t.Fatalf("query text not bound: %v", args)
+	}
+}
+
+func TestDeleteMany(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	seedTestModels(t, db, "a", "b", "c")
+	createTables(t, db, (*taggedSoftModel)(nil), (*nativeSoftModel)(nil))
+	insertRows(t, db,
+		&taggedSoftModel{Name: "a"}, &taggedSoftModel{Name: "b"},
+		&nativeSoftModel{Name: "a"}, &nativeSoftModel{Name: "b"},
+	)
+	m := testModels(t, db)
+	log.reset()
+
+	if n, err := m.DeleteMany(ctx, (*testModel)(nil), []int64{}); err != nil || n != 0 {
+		t.Fatalf("empty: %d, %v", n, err)
+	}
+
+	if q := log.last(); q != "" {
+		t.Fatalf("unexpected query: %s", q)
+	}
+
+	if n, err := m.DeleteMany(ctx, (*testModel)(nil), []int64{1, 3}); err != nil || n != 2 {
+		t.Fatalf("hard: %d, %v", n, err)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	for _, model := range []any{(*taggedSoftModel)(nil), (*nativeSoftModel)(nil)} {
+		if n, err := m.DeleteMany(ctx, model, []int64{1}); err != nil || n != 1 {
+			t.Fatalf("soft %T: %d, %v", model, n, err)
+		}
+
+		if n, err := m.Count(ctx, model, nil); err != nil || n != 1 {
+			t.Fatalf("%T deleted row counted: %d, %v", model, n, err)
+		}
+
+		if n, err := m.Count(ctx, model, nil, WithTrashed()); err != nil || n != 2 {
+			t.Fatalf("%T row hard deleted: %d, %v", model, n, err)
+		}
+	}
+}
+
+type switchStatus string
+
+func (s switchStatus) Valid() bool {
+	return s == "on" || s == "off"
+}
+
+func TestEnumValidator(t *testing.T) {
+	ctx := context.Background()
+
+	type bySwitch struct {
+		Status *switchStatus `field:"status"`
+	}
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Status: "on"}, &testModel{Name: "b", Status: "off"})
+	m := testModels(t, db)
+
+	on, bogus := switchStatus("on"), switchStatus("bogus")
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, bySwitch{Status: &on}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "a" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, bySwitch{Status: &bogus}); !errors.Is(err, ErrInvalidEnum) {
+		t.Fatalf("expected ErrInvalidEnum, got %v", err)
+	}
+
+	if err := m.List(ctx, &vs, bySwitch{Status: &bogus}, SkipInvalidEnums()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("expected invalid filter skipped: %+v", vs)
+	}
+}
+
+type secretModel struct {
+	bun.BaseModel `bun:"table:secret_models"`
+
+	ID    int64  `bun:"id,pk,autoincrement"`
+	Email string `bun:"email" model:"update"`
+	Blob  []byte `bun:"blob"`
+}
+
+func encodeBase64(b []byte) ([]byte, error) {
+	return []byte(base64.StdEncoding.EncodeToString(b)), nil
+}
+
+func decodeBase64(b []byte) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(string(b))
+}
+
+func rawEmail(t *testing.T, db *bun.DB, id int64) string {
+	t.Helper()
+
+	var email string
+
+	if err := db.NewRaw("SELECT email FROM secret_models WHERE id = ?", id).Scan(context.Background(), &email); err != nil {
+		t.Fatal(err)
+	}
+
+	return email
+}
+
+func TestColumnCrypter(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*secretModel)(nil))
+	m := testModels(t, db, WithColumnCrypter([]string{"email", "blob"}, encodeBase64, decodeBase64))
+
+	v := &secretModel{Email: "a@b.c", Blob: []byte("xyz")}
+
+	if err := m.Create(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Email != "a@b.c" || string(v.Blob) != "xyz" {
+		t.Fatalf("model left encrypted: %+v", v)
+	}
+
+	if raw := rawEmail(t, db, 1); raw != "YUBiLmM=" {
+		t.Fatalf("expected ciphertext stored, got %q", raw)
+	}
+
+	g := &secretModel{ID: 1}
+
+	if err := m.Get(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Email != "a@b.c" || string(g.Blob) != "xyz" {
+		t.Fatalf("not decrypted: %+v", g)
+	}
+
+	g.Email = "d@e.f"
+
+	if err := m.Update(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	g.Email = "g@h.i"
+
+	if err := m.Save(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Email != "g@h.i" {
+		t.Fatalf("model left encrypted: %+v", g)
+	}
+
+	if raw := rawEmail(t, db, 1); raw == "g@h.i" {
+		t.Fatal("saved plaintext")
+	}
+
+	var vs []secretModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Email != "g@h.i" {
+		t.Fatalf("not decrypted: %+v", vs)
+	}
+
+	f := &secretModel{}
+
+	if err := m.Find(ctx, f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Email != "g@h.i" {
+		t.Fatalf("not decrypted: %+v", f)
+	}
+
+	if err := testModels(t, db, WithColumnCrypter([]string{"id"}, encodeBase64, decodeBase64)).Get(ctx, &secretModel{ID: 1}); err == nil {
+		t.Fatal("expected non-string column to fail")
+	}
+}
+
+func TestColumnCrypterReads(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*secretModel)(nil))
+	m := testModels(t, db, WithColumnCrypter([]string{"email", "blob"}, encodeBase64, decodeBase64))
+
+	for _, email := range []string{"hunter2", "b@x"} {
+		if err := m.Create(ctx, &secretModel{Email: email}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	one := &secretModel{}
+
+	if err := m.FindOne(ctx, one, nil, Where("id = 1")); err != nil || one.Email != "hunter2" {
+		t.Fatalf("FindOne: %+v, %v", one, err)
+	}
+
+	var vs []secretModel
+
+	if _, err := m.ListPage(ctx, &vs, nil, Page{Limit: 5}); err != nil || vs[0].Email != "hunter2" {
+		t.Fatalf("ListPage: %+v, %v", vs, err)
+	}
+
+	if _, err := m.ListMore(ctx, &vs, nil, 1); err != nil || vs[0].Email != "hunter2" {
+		t.Fatalf("ListMore: %+v, %v", vs, err)
+	}
+
+	if err := m.GetMany(ctx, &vs, []int64{1, 2}); err != nil || len(vs) != 2 || vs[1].Email != "b@x" {
+		t.Fatalf("GetMany: %+v, %v", vs, err)
+	}
+
+	g := &secretModel{ID: 1}
+
+	if err := m.GetForUpdate(ctx, g); err != nil || g.Email != "hunter2" {
+		t.Fatalf("GetForUpdate: %+v, %v", g, err)
+	}
+
+	var into struct {
+		Email string
+	}
+
+	if err := m.GetInto(ctx, (*secretModel)(nil), &into, nil, Where("id = 1")); err != nil || into.Email != "hunter2" {
+		t.Fatalf("GetInto: %+v, %v", into, err)
+	}
+
+	var emails []string
+
+	err := m.Each(ctx, (*secretModel)(nil), nil, func(row any) error {
+		emails = append(emails, row.(*secretModel).Email)
+		return nil
+	})
+	if err != nil || strings.Join(emails, ",") != "hunter2,b@x" {
+		t.Fatalf("Each: %v, %v", emails, err)
+	}
+
+	emails = nil
+
+	err = m.EachBatch(ctx, &vs, nil, 1, func(batch any) error {
+		emails = append(emails, batch.([]secretModel)[0].Email)
+		return nil
+	})
+	if err != nil || strings.Join(emails, ",") != "hunter2,b@x" {
+		t.Fatalf("EachBatch: %v, %v", emails, err)
+	}
+
+	emails = nil
+
+	if err := m.Pluck(ctx, (*secretModel)(nil), "email", &emails, nil); err != nil || emails[0] != "hunter2" {
+		t.Fatalf("Pluck: %v, %v", emails, err)
+	}
+
+	counts, err := m.CountBy(ctx, (*secretModel)(nil), "email", nil)
+	if err != nil || counts["hunter2"] != 1 {
+		t.Fatalf("CountBy: %v, %v", counts, err)
+	}
+
+	if _, err := m.UpdateColumns(ctx, &secretModel{ID: 1}, map[string]any{"email": "new"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw := rawEmail(t, db, 1); raw == "new" {
+		t.Fatal("UpdateColumns wrote plaintext")
+	}
+
+	if _, err := m.UpdateWhere(ctx, (*secretModel)(nil), map[string]any{"email": "all"}, All); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw := rawEmail(t, db, 2); raw == "all" {
+		t.Fatal("UpdateWhere wrote plaintext")
+	}
+
+	d := &secretModel{ID: 2}
+
+	if err := m.DeleteReturning(ctx, d); err != nil || d.Email != "all" {
+		t.Fatalf("DeleteReturning: %+v, %v", d, err)
+	}
+}
+
+func TestExprFilter(t *testing.T) {
+	ctx := context.Background()
+
+	type byEmail struct {
+		Email *string `field:"LOWER(email),expr"`
+	}
+
+	type byDomain struct {
+		Email *string `field:"upper(email),expr,like"`
+	}
+
+	type byUnknownFunc struct {
+		Email *string `field:"SLEEP(email),expr"`
+	}
+
+	type byUnknownColumn struct {
+		Email *string `field:"LOWER(nope),expr"`
+	}
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Email: "Foo@X.com"}, &testModel{Name: "b", Email: "bar@x.com"})
+	m := testModels(t, db, WithStrictFilters())
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, byEmail{Email: strPtr("foo@x.com")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "a" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	q := m.SelectWith((*testModel)(nil))
+
+	if err := m.queryArgs(q, (*testModel)(nil), byEmail{Email: strPtr("foo@x.com")}, m.opts); err != nil {
+		t.Fatal(err)
+	}
+
+	query, args, err := m.Compile(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, `LOWER("test_model".email) = ?`)
+
+	if len(args) != 1 || args[0] != "foo@x.com" {
+		t.Fatalf("value not bound: %v", args)
+	}
+
+	if err := m.List(ctx, &vs, byDomain{Email: strPtr("%X.COM")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, byUnknownFunc{Email: strPtr("x")}); err == nil || !strings.Contains(err.Error(), "invalid filter expression") {
+		t.Fatalf("expected invalid filter expression, got %v", err)
+	}
+
+	if err := m.List(ctx, &vs, byUnknownColumn{Email: strPtr("x")}); err == nil {
+		t.Fatal("expected unknown column to fail")
+	}
+}
+
+func TestClaimNext(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testPostgres(t)
+	createTables(t, db, (*testModel)(nil))
+	m := testModels(t, db)
+
+	if err := m.ClaimNext(ctx, &testModel{}, nil); err == nil {
+		t.Fatal("expected claim outside a transaction to fail")
+	}
+
+	err := m.Transaction(ctx, func(tm *Models) error {
+		// SQLite cannot run the locking clause, only its SQL is checked
+		if err := tm.ClaimNext(ctx, &testModel{}, nil); err == nil {
+			t.Fatal("expected SQLite to reject FOR UPDATE")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+
+	for _, q := range log.queries {
+		if strings.Contains(q, `ORDER BY "test_model"."id" LIMIT 1 FOR UPDATE SKIP LOCKED`) {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("claim not locked: %v", log.queries)
+	}
+
+	sdb, _ := testSQLite(t)
+	createTables(t, sdb, (*testModel)(nil))
+	insertRows(t, sdb, &testModel{Name: "a", Status: "q"}, &testModel{Name: "z", Status: "q"})
+	m = testModels(t, sdb, WithDefaultOrder("name DESC"))
+
+	err = m.Transaction(ctx, func(tm *Models) error {
+		v := &testModel{}
+
+		if err := tm.ClaimNext(ctx, v, testQueryArgs{Status: strPtr("q")}, OrderBy("name DESC")); err != nil {
+			return err
+		}
+
+		if v.ID != 1 {
+			t.Fatalf("claimed out of primary key order: %+v", v)
+		}
+
+		if err := tm.ClaimNext(ctx, v, testQueryArgs{Status: strPtr("none")}); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeleteReturning(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil), (*taggedSoftModel)(nil), (*nativeSoftModel)(nil))
+	insertRows(t, db,
+		&testModel{Name: "a", Email: "e", Status: "s"},
+		&taggedSoftModel{Name: "t"},
+		&nativeSoftModel{Name: "n"},
+	)
+	m := testModels(t, db)
+
+	v := &testModel{ID: 1}
+
+	if err := m.DeleteReturning(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "a" || v.Email != "e" || v.Status != "s" {
+		t.Fatalf("deleted row not returned: %+v", v)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 0 {
+		t.Fatalf("row not deleted: %d, %v", n, err)
+	}
+
+	ts := &taggedSoftModel{ID: 1}
+
+	if err := m.DeleteReturning(ctx, ts); err != nil || ts.Name != "t" || ts.DeletedAt == nil {
+		t.Fatalf("soft deleted row not returned: %+v, %v", ts, err)
+	}
+
+	ns := &nativeSoftModel{ID: 1}
+
+	if err := m.DeleteReturning(ctx, ns); err != nil || ns.Name != "n" || ns.DeletedAt.IsZero() {
+		t.Fatalf("soft deleted row not returned: %+v, %v", ns, err)
+	}
+
+	if n, err := m.Count(ctx, (*nativeSoftModel)(nil), nil, WithTrashed()); err != nil || n != 1 {
+		t.Fatalf("row hard deleted: %d, %v", n, err)
+	}
+
+	mdb, _ := testMySQL(t)
+
+	if err := testModels(t, mdb).DeleteReturning(ctx, &testModel{ID: 1}); err == nil {
+		t.Fatal("expected MySQL to fail")
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	ctx := context.Background()
+	frozen := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*taggedSoftModel)(nil), (*stampedModel)(nil))
+	insertRows(t, db, &taggedSoftModel{Name: "t"}, &stampedModel{Name: "s"})
+	m := testModels(t, db, WithClock(func() time.Time { return frozen }))
+
+	s := &stampedModel{ID: 1}
+
+	if err := m.Touch(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Get(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.UpdatedAt.Equal(frozen) {
+		t.Fatalf("expected updated_at %v, got %v", frozen, s.UpdatedAt)
+	}
+
+	if err := m.Delete(ctx, &taggedSoftModel{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	v := &taggedSoftModel{ID: 1}
+
+	if err := m.Get(ctx, v, WithTrashed()); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.DeletedAt == nil || !v.DeletedAt.Equal(frozen) {
+		t.Fatalf("expected deleted_at %v, got %v", frozen, v.DeletedAt)
+	}
+
+	cutoff := frozen.Add(-24 * time.Hour)
+	older := cutoff.Add(-time.Second)
+
+	insertRows(t, db, &taggedSoftModel{Name: "cutoff", DeletedAt: &cutoff}, &taggedSoftModel{Name: "older", DeletedAt: &older})
+
+	n, err := m.Purge(ctx, (*taggedSoftModel)(nil), 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 1 {
+		t.Fatalf("expected only the row past the cutoff purged, got %d", n)
+	}
+}
+
+type conventionModel struct {
+	bun.BaseModel `bun:"table:convention_models"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	RemovedAt time.Time `bun:"removed_at"`
+	Gone      bool      `bun:"gone"`
+}
+
+func TestWithSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	epoch := time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*conventionModel)(nil))
+	insertRows(t, db, &conventionModel{RemovedAt: epoch}, &conventionModel{RemovedAt: epoch})
+
+	m := testModels(t, db, WithSoftDelete("removed_at", nil, "?TableAlias.removed_at = '1970-01-01 00:00:00+00:00'"))
+
+	if n, err := m.Count(ctx, (*conventionModel)(nil), nil); err != nil || n != 2 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.Delete(ctx, &conventionModel{ID: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*conventionModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("deleted row counted: %d, %v", n, err)
+	}
+
+	if n, err := m.Count(ctx, (*conventionModel)(nil), nil, OnlyTrashed()); err != nil || n != 1 {
+		t.Fatalf("deleted row not trashed: %d, %v", n, err)
+	}
+
+	m = testModels(t, db, WithSoftDelete("gone", true, ""))
+
+	if err := m.Delete(ctx, &conventionModel{ID: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := m.Count(ctx, (*conventionModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("deleted row counted: %d, %v", n, err)
+	}
+
+	if n, err := m.Restore(ctx, &conventionModel{ID: 2}); err != nil || n != 1 {
+		t.Fatalf("restore %d: %v", n, err)
+	}
+
+	if n, err := m.Count(ctx, (*conventionModel)(nil), nil); err != nil || n != 2 {
+		t.Fatalf("restored row not counted: %d, %v", n, err)
+	}
+}
+
+type membershipGroupModel struct {
+	bun.BaseModel `bun:"table:membership_group_models"`
+
+	ID     int64  `bun:"id,pk,autoincrement"`
+	OrgID  int64  `bun:"org_id,unique:org_user"`
+	UserID int64  `bun:"user_id,unique:org_user"`
+	Code   string `bun:"code,unique"`
+	Role   string `bun:"role" model:"update"`
+}
+
+func TestConflictOn(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*membershipGroupModel)(nil))
+	m := testModels(t, db)
+
+	if err := m.Upsert(ctx, &membershipGroupModel{OrgID: 1, UserID: 2, Code: "a", Role: "x"}, ConflictOn("org_user")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Upsert(ctx, &membershipGroupModel{OrgID: 1, UserID: 2, Code: "b", Role: "y"}, ConflictOn("org_user")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `ON CONFLICT ("org_id", "user_id") DO UPDATE SET "role" = EXCLUDED."role"`)
+
+	var vs []membershipGroupModel
+
+	if err := m.List(ctx, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Role != "y" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.Upsert(ctx, &membershipGroupModel{OrgID: 3, UserID: 3, Code: "a", Role: "z"}, ConflictOn("code")); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `ON CONFLICT ("code")`)
+
+	if err := m.Upsert(ctx, &membershipGroupModel{}, ConflictOn("nope")); err == nil {
+		t.Fatal("expected unknown group to fail")
+	}
+}
+
+func TestListReplacesSlice(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedTestModels(t, db, "a", "b")
+	m := testModels(t, db)
+
+	vs := []testModel{{Name: "old"}, {Name: "older"}, {Name: "oldest"}}
+
+	if err := m.List(ctx, &vs, testQueryArgs{Name: strPtr("b")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Name != "b" {
+		t.Fatalf("stale entries kept: %+v", vs)
+	}
+
+	ps := []*testModel{{Name: "old"}}
+
+	if err := m.List(ctx, &ps, testQueryArgs{Name: strPtr("zzz")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ps) != 0 {
+		t.Fatalf("stale entries kept: %+v", ps)
+	}
+}
+
+func TestRelationFn(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	seedAuthors(t, db)
+	m := testModels(t, db)
+
+	var vs []authorModel
+
+	byTitle := RelationFn("Books", func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Order("title DESC")
+	})
+
+	if err := m.List(ctx, &vs, nil, byTitle, Relation("Profile")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].Profile == nil {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	var titles []string
+
+	for _, b := range vs[0].Books {
+		titles = append(titles, b.Title)
+	}
+
+	if strings.Join(titles, "") != "zyx" {
+		t.Fatalf("unexpected order: %v", titles)
+	}
+}
+
+func TestCountBy(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModelWithMultipleTags)(nil))
+	insertRows(t, db,
+		&testModelWithMultipleTags{Name: "a", Status: "on", Version: 1},
+		&testModelWithMultipleTags{Name: "b", Status: "on", Version: 2},
+		&testModelWithMultipleTags{Name: "c", Status: "off", Version: 2},
+	)
+	m := testModels(t, db)
+
+	counts, err := m.CountBy(ctx, (*testModelWithMultipleTags)(nil), "status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(counts, map[string]int{"on": 2, "off": 1}) {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+
+	counts, err = m.CountBy(ctx, (*testModelWithMultipleTags)(nil), "version", testQueryArgs{Status: strPtr("on")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(counts, map[string]int{"1": 1, "2": 1}) {
+		t.Fatalf("unexpected counts: %v", counts)
+	}
+
+	if _, err := m.CountBy(ctx, (*testModelWithMultipleTags)(nil), "nope", nil); err == nil {
+		t.Fatal("expected unknown column to fail")
+	}
+}
+
+// flakyDriver fails the next failures queries with sql.ErrConnDone.
+type flakyDriver struct {
+	driver.Driver
+}
+
+var failures int32
+
+func (d flakyDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return flakyConn{c}, nil
+}
+
+type flakyConn struct {
+	driver.Conn
+}
+
+func (c flakyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if atomic.AddInt32(&failures, -1) >= 0 {
+		return nil, sql.ErrConnDone
+	}
+
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c flakyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func init() {
+	sql.Register("stdmodel-flaky", flakyDriver{sqliteshim.Driver()})
+}
+
+func TestAutoRetry(t *testing.T) {
+	ctx := context.Background()
+
+	db := bun.NewDB(openSQLite(t, "stdmodel-flaky"), sqlitedialect.New())
+	seedTestModels(t, db, "a")
+
+	t.Cleanup(func() { atomic.StoreInt32(&failures, 0) })
+
+	atomic.StoreInt32(&failures, 1)
+
+	if err := testModels(t, db).Get(ctx, &testModel{ID: 1}); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected sql.ErrConnDone without retries, got %v", err)
+	}
+
+	m := testModels(t, db, WithAutoRetry(2))
+
+	atomic.StoreInt32(&failures, 1)
+
+	v := &testModel{ID: 1}
+
+	if err := m.Get(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "a" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	atomic.StoreInt32(&failures, 3)
+
+	if err := m.Get(ctx, v); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("expected retries exhausted, got %v", err)
+	}
+
+	atomic.StoreInt32(&failures, 1)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil); err != nil || len(vs) != 1 {
+		t.Fatalf("list %+v: %v", vs, err)
+	}
+
+	atomic.StoreInt32(&failures, 1)
+
+	if n, err := m.Count(ctx, (*testModel)(nil), nil); err != nil || n != 1 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	atomic.StoreInt32(&failures, 1)
+
+	if ok, err := m.Exists(ctx, (*testModel)(nil), nil); err != nil || !ok {
+		t.Fatalf("exists %v: %v", ok, err)
+	}
+}
+
+func TestIndexHint(t *testing.T) {
+	db, _ := testMySQL(t)
+	m := testModels(t, db)
+
+	query := m.SelectWith((*testModel)(nil), IndexHint("FORCE INDEX (idx_email)")).String()
+
+	assertContains(t, query, `FROM "test_models" AS "test_model" FORCE INDEX ("idx_email")`)
+
+	query = m.SelectWith((*testModel)(nil), IndexHint("use index (a, b)")).String()
+
+	assertContains(t, query, `USE INDEX ("a", "b")`)
+
+	if _, _, err := m.Compile(m.SelectWith((*testModel)(nil), IndexHint("FORCE INDEX (a); DROP"))); err == nil {
+		t.Fatal("expected invalid hint to fail")
+	}
+
+	sdb, _ := testSQLite(t)
+
+	query = testModels(t, sdb).SelectWith((*testModel)(nil), IndexHint("FORCE INDEX (a)")).String()
+
+	assertNotContains(t, query, "INDEX")
+}
+
+func TestUpdateWhere(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db,
+		&testModel{Name: "a", Status: "pending"},
+		&testModel{Name: "b", Status: "pending"},
+		&testModel{Name: "c", Status: "done"},
+	)
+	m := testModels(t, db)
+
+	n, err := m.UpdateWhere(ctx, (*testModel)(nil), map[string]any{"status": "expired"}, testQueryArgs{Status: strPtr("pending")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("expected 2 rows, got %d", n)
+	}
+
+	if n, err := m.Count(ctx, (*testModel)(nil), testQueryArgs{Status: strPtr("expired")}); err != nil || n != 2 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if _, err := m.UpdateWhere(ctx, (*testModel)(nil), map[string]any{"status": "x"}, nil); !errors.Is(err, ErrNoFilters) {
+		t.Fatalf("expected ErrNoFilters, got %v", err)
+	}
+
+	if _, err := m.UpdateWhere(ctx, (*testModel)(nil), map[string]any{"nope": "x"}, All); !errors.Is(err, ErrUnknownColumn) {
+		t.Fatalf("expected ErrUnknownColumn, got %v", err)
+	}
+
+	if n, err := m.UpdateWhere(ctx, (*testModel)(nil), map[string]any{"version": 9}, All); err != nil || n != 3 {
+		t.Fatalf("all: %d, %v", n, err)
+	}
+
+	mdb, log := testMySQL(t)
+	createTables(t, mdb, (*testModel)(nil))
+	insertRows(t, mdb, &testModel{Name: "a", Status: "x"}, &testModel{Name: "b", Status: "y"})
+
+	if n, err := testModels(t, mdb).UpdateWhere(ctx, (*testModel)(nil), map[string]any{"name": "z"}, testQueryArgs{Status: strPtr("x")}); err != nil || n != 1 {
+		t.Fatalf("mysql: %d, %v", n, err)
+	}
+
+	assertContains(t, log.last(), `) AS "matching"`)
+}
+
+func TestPluck(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*testModel)(nil))
+	insertRows(t, db, &testModel{Name: "a", Email: "a@x"}, &testModel{Name: "b", Email: "b@x", Status: "on"})
+	m := testModels(t, db)
+
+	var emails []string
+
+	if err := m.Pluck(ctx, (*testModel)(nil), "email", &emails, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(emails, ",") != "a@x,b@x" {
+		t.Fatalf("unexpected emails: %v", emails)
+	}
+
+	if err := m.Pluck(ctx, (*testModel)(nil), "email", &emails, nil, OrderBy("email DESC")); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(emails, ",") != "b@x,a@x" {
+		t.Fatalf("unexpected emails: %v", emails)
+	}
+
+	var ids []int64
+
+	if err := m.Pluck(ctx, (*testModel)(nil), "id", &ids, testQueryArgs{Status: strPtr("on")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 1 || ids[0] != 2 {
+		t.Fatalf("unexpected ids: %v", ids)
+	}
+
+	var id int64
+
+	if err := m.Pluck(ctx, (*testModel)(nil), "id", &id, nil); !errors.Is(err, ErrNotSlicePointer) {
+		t.Fatalf("expected ErrNotSlicePointer, got %v", err)
+	}
+}
+
+func TestOrderNulls(t *testing.T) {
+	ctx := context.Background()
+
+	type byEmail struct {
+		Email *string `field:"email" order:"asc nulls first"`
+	}
+
+	db, _ := testPostgres(t)
+	m := testModels(t, db)
+
+	query, err := m.Explain(ctx, (*testModel)(nil), nil, OrderBy("email DESC NULLS LAST, id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, "ORDER BY email DESC NULLS LAST, id")
+
+	query, err = m.Explain(ctx, (*testModel)(nil), byEmail{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, query, `ORDER BY "email" ASC NULLS FIRST`)
+
+	if _, err := m.Explain(ctx, (*testModel)(nil), nil, OrderBy("email NULLS WHATEVER")); err == nil {
+		t.Fatal("expected invalid nulls placement to fail")
+	}
+
+	mdb, _ := testMySQL(t)
+
+	query = testModels(t, mdb).SelectWith((*testModel)(nil), OrderBy("author.email desc nulls last, id")).String()
+
+	assertContains(t, query, "ORDER BY author.email IS NULL ASC, author.email desc, id")
+
+	sdb, _ := testSQLite(t)
+	createTables(t, sdb, (*testModel)(nil))
+	insertRows(t, sdb, &testModel{Name: "a", Email: "x"})
+
+	if _, err := sdb.ExecContext(ctx, "INSERT INTO test_models (name, email, status, version) VALUES ('b', NULL, '', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	m = testModels(t, sdb)
+
+	var vs []testModel
+
+	if err := m.List(ctx, &vs, nil, OrderBy("email ASC NULLS LAST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if vs[0].Name != "a" {
+		t.Fatalf("expected nulls last: %+v", vs)
+	}
+
+	if err := m.List(ctx, &vs, nil, OrderBy("email ASC NULLS FIRST")); err != nil {
+		t.Fatal(err)
+	}
+
+	if vs[0].Name != "b" {
+		t.Fatalf("expected nulls first: %+v", vs)
+	}
+}
+
+type generatedModel struct {
+	bun.BaseModel `bun:"table:generated_models"`
+
+	ID    int64  `bun:"id,pk,autoincrement"`
+	Name  string `bun:"name" model:"update"`
+	Token string `bun:"token"`
+}
+
+func (*generatedModel) CustomizeInsert(q *bun.InsertQuery) *bun.InsertQuery {
+	return q.ExcludeColumn("token").Returning("id, token")
+}
+
+func TestInsertCustomizer(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+
+	if _, err := db.ExecContext(ctx, "CREATE TABLE generated_models (id INTEGER PRIMARY KEY, name TEXT, token TEXT DEFAULT 'generated')"); err != nil {
+		t.Fatal(err)
+	}
+
+	m := testModels(t, db)
+
+	v := &generatedModel{Name: "a", Token: "client"}
+
+	if err := m.Create(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, log.last(), "client")
+
+	if v.ID != 1 || v.Token != "generated" {
+		t.Fatalf("generated column not returned: %+v", v)
+	}
+
+	if err := m.Save(ctx, &generatedModel{ID: 1, Name: "b", Token: "client"}); err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, log.last(), "client")
+
+	g := &generatedModel{ID: 1}
+
+	if err := m.Get(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	if g.Name != "b" || g.Token != "generated" {
+		t.Fatalf("unexpected row: %+v", g)
+	}
+}
+
+type scopedModel struct {
+	bun.BaseModel `bun:"table:scoped_models"`
+
+	ID     int64  `bun:"id,pk,autoincrement"`
+	Active bool   `bun:"active"`
+	Role   string `bun:"role"`
+}
+
+func (*scopedModel) Scopes() map[string]func(*bun.SelectQuery) *bun.SelectQuery {
+	return map[string]func(*bun.SelectQuery) *bun.SelectQuery{
+		"active": func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.active = ?", true)
+		},
+		"admins": func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("?TableAlias.role = ?", "admin")
+		},
+	}
+}
+
+func TestScope(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*scopedModel)(nil), (*testModel)(nil))
+	insertRows(t, db,
+		&scopedModel{Active: true, Role: "admin"},
+		&scopedModel{Active: false, Role: "admin"},
+		&scopedModel{Active: true, Role: "user"},
+	)
+	m := testModels(t, db)
+
+	var vs []scopedModel
+
+	if err := m.List(ctx, &vs, nil, Scope("active"), Scope("admins")); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 1 || vs[0].ID != 1 {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if n, err := m.Count(ctx, (*scopedModel)(nil), nil, Scope("active")); err != nil || n != 2 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.List(ctx, &vs, nil, Scope("nope")); err == nil {
+		t.Fatal("expected unknown scope to fail")
+	}
+
+	if err := m.List(ctx, &[]testModel{}, nil, Scope("active")); err == nil {
+		t.Fatal("expected scope on a model without scopes to fail")
+	}
+}
+
+func TestDialect(t *testing.T) {
+	db, _ := testSQLite(t)
+
+	if d := testModels(t, db).Dialect(); d != DialectSQLite {
+		t.Fatalf("expected DialectSQLite, got %v", d)
+	}
+
+	pdb, _ := testPostgres(t)
+	m := testModels(t, pdb)
+
+	if d := m.With().Dialect(); d != DialectPostgres {
+		t.Fatalf("expected DialectPostgres, got %v", d)
+	}
+
+	mdb, _ := testMySQL(t)
+
+	if d := testModels(t, mdb).Dialect(); d != DialectMySQL {
+		t.Fatalf("expected DialectMySQL, got %v", d)
+	}
+}
+
+type wideModel struct {
+	bun.BaseModel `bun:"table:wide_models"`
+
+	ID                        int64  `bun:"id,pk,autoincrement"`
+	A, B, C, D, E, F, G, H, I string `bun:",nullzero"`
+}
+
+func TestCreateManyChunks(t *testing.T) {
+	ctx := context.Background()
+
+	inserts := func(log *queryLog) int {
+		log.mu.Lock()
+		defer log.mu.Unlock()
+
+		n := 0
+
+		for _, q := range log.queries {
+			if strings.HasPrefix(q, "INSERT") {
+				n++
+			}
+		}
+
+		return n
+	}
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*wideModel)(nil))
+	m := testModels(t, db)
+
+	vs := make([]wideModel, 8000)
+
+	for i := range vs {
+		vs[i].A = "x"
+	}
+
+	log.reset()
+
+	if err := m.CreateMany(ctx, &vs); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := inserts(log); n != 3 {
+		t.Fatalf("expected 3 inserts, got %d", n)
+	}
+
+	if vs[7999].ID != 8000 {
+		t.Fatalf("ids not set on every chunk: %d", vs[7999].ID)
+	}
+
+	if n, err := m.Count(ctx, (*wideModel)(nil), nil); err != nil || n != 8000 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	small := []wideModel{{A: "s"}}
+
+	log.reset()
+
+	if err := m.CreateMany(ctx, &small); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(log.queries); n != 1 {
+		t.Fatalf("expected a single statement, got %v", log.queries)
+	}
+
+	empty := []wideModel{}
+
+	log.reset()
+
+	if err := m.CreateMany(ctx, &empty); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SaveMany(ctx, &empty); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(log.queries); n != 0 {
+		t.Fatalf("expected no statements, got %v", log.queries)
+	}
+}
+
+type tenantDocModel struct {
+	bun.BaseModel `bun:"table:tenant_doc_models"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	TenantID  int64      `bun:"tenant_id"`
+	Name      string     `bun:"name"`
+	DeletedAt *time.Time `bun:"deleted_at"`
+}
+
+type tenantIDKey struct{}
+
+type tenantOpKey struct{}
+
+func (*tenantDocModel) ContextFilters(ctx context.Context) []FilterClause {
+	id, ok := ctx.Value(tenantIDKey{}).(int64)
+	if !ok {
+		return nil
+	}
+
+	op, _ := ctx.Value(tenantOpKey{}).(string)
+
+	return []FilterClause{{Column: "tenant_id", Op: op, Value: id}}
+}
+
+func TestContextFilterer(t *testing.T) {
+	ctx := context.Background()
+	one := context.WithValue(ctx, tenantIDKey{}, int64(1))
+	two := context.WithValue(ctx, tenantIDKey{}, int64(2))
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*tenantDocModel)(nil))
+	insertRows(t, db,
+		&tenantDocModel{TenantID: 1, Name: "a"},
+		&tenantDocModel{TenantID: 2, Name: "b"},
+		&tenantDocModel{TenantID: 1, Name: "c"},
+	)
+	m := testModels(t, db, WithSoftDeleteColumn("deleted_at"))
+
+	var vs []tenantDocModel
+
+	if err := m.List(one, &vs, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].Name != "a" || vs[1].Name != "c" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if n, err := m.Count(one, (*tenantDocModel)(nil), nil); err != nil || n != 2 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if err := m.Get(one, &tenantDocModel{ID: 2}); err == nil {
+		t.Fatal("expected another tenant's row hidden")
+	}
+
+	v := &tenantDocModel{}
+
+	if err := m.Find(two, v, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Name != "b" {
+		t.Fatalf("unexpected row: %+v", v)
+	}
+
+	if n, err := m.Count(ctx, (*tenantDocModel)(nil), nil); err != nil || n != 3 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	for _, opt := range []Option{OnlyTrashed(), WithTrashed()} {
+		query, err := m.Explain(one, (*tenantDocModel)(nil), nil, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assertContains(t, query, `"tenant_doc_model".tenant_id = 1`)
+	}
+
+	query, err := m.Explain(one, (*tenantDocModel)(nil), nil, NoDefaults())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertNotContains(t, query, "tenant_id =")
+
+	for _, op := range []string{">", "ILIKE"} {
+		if _, err := m.Explain(context.WithValue(one, tenantOpKey{}, op), (*tenantDocModel)(nil), nil); err == nil {
+			t.Fatalf("expected op %q to fail", op)
+		}
+	}
+}
+
+type importedModel struct {
+	bun.BaseModel `bun:"table:imported_models"`
+
+	ID   int64  `bun:"id,pk,autoincrement"`
+	Code string `bun:"code,unique"`
+}
+
+func TestCreateManyResult(t *testing.T) {
+	ctx := context.Background()
+
+	db, _ := testSQLite(t)
+	createTables(t, db, (*importedModel)(nil))
+	m := testModels(t, db)
+
+	if n, err := m.CreateManyResult(ctx, &[]importedModel{{Code: "a"}, {Code: "b"}}); err != nil || n != 2 {
+		t.Fatalf("created %d: %v", n, err)
+	}
+
+	vs := []importedModel{{Code: "b"}, {Code: "c"}, {Code: "a"}, {Code: "d"}}
+
+	n, err := m.CreateManyResult(ctx, &vs, IgnoreConflict())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != 2 {
+		t.Fatalf("expected 2 new rows, got %d", n)
+	}
+
+	if n, err := m.Count(ctx, (*importedModel)(nil), nil); err != nil || n != 4 {
+		t.Fatalf("count %d: %v", n, err)
+	}
+
+	if n, err := m.CreateManyResult(ctx, &[]importedModel{}, IgnoreConflict()); err != nil || n != 0 {
+		t.Fatalf("created %d: %v", n, err)
+	}
+}
+
+type nowStampedModel struct {
+	bun.BaseModel `bun:"table:now_stamped_models"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	UpdatedAt time.Time  `bun:"updated_at" model:"updated"`
+	DeletedAt *time.Time `bun:"deleted_at"`
+}
+
+func TestNowExpression(t *testing.T) {
+	ctx := context.Background()
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*nowStampedModel)(nil))
+	insertRows(t, db, &nowStampedModel{})
+	m := testModels(t, db, WithNowExpression(), WithSoftDeleteColumn("deleted_at"))
+
+	v := &nowStampedModel{ID: 1}
+
+	if err := m.Touch(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `"updated_at" = CURRENT_TIMESTAMP`)
+
+	if v.UpdatedAt.IsZero() {
+		t.Fatalf("written time not returned: %+v", v)
+	}
+
+	if err := m.Delete(ctx, v); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `"deleted_at" = CURRENT_TIMESTAMP`)
+
+	if v.DeletedAt == nil {
+		t.Fatalf("written time not returned: %+v", v)
+	}
+
+	if err := m.Touch(ctx, &nowStampedModel{ID: 9}); err == nil {
+		t.Fatal("expected missing row to fail")
+	}
+
+	pdb, plog := testPostgres(t)
+	createTables(t, pdb, (*nowStampedModel)(nil))
+
+	if _, err := pdb.ExecContext(ctx, "INSERT INTO now_stamped_models (id, updated_at) VALUES (1, CURRENT_TIMESTAMP)"); err != nil {
+		t.Fatal(err)
+	}
+
+	// SQLite has no now(), only the SQL is checked
+	if err := testModels(t, pdb, WithNowExpression()).Touch(ctx, &nowStampedModel{ID: 1}); err == nil {
+		t.Fatal("expected SQLite to reject now()")
+	}
+
+	assertContains(t, plog.last(), `"updated_at" = now()`)
+}
+
+type memberModel struct {
+	bun.BaseModel `bun:"table:member_models"`
+
+	ID        int64      `bun:"id,pk,autoincrement"`
+	Email     string     `bun:"email"`
+	Name      string     `bun:"name"`
+	DeletedAt *time.Time `bun:"deleted_at"`
+}
+
+func TestConflictTarget(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	live := ConflictTarget("email", "WHERE deleted_at IS NULL")
+
+	db, log := testSQLite(t)
+	createTables(t, db, (*memberModel)(nil))
+
+	if _, err := db.ExecContext(ctx, "CREATE UNIQUE INDEX member_models_email ON member_models (email) WHERE deleted_at IS NULL"); err != nil {
+		t.Fatal(err)
+	}
+
+	insertRows(t, db,
+		&memberModel{Email: "a@x", Name: "old", DeletedAt: &now},
+		&memberModel{Email: "a@x", Name: "live"},
+	)
+	m := testModels(t, db)
+
+	if err := m.Upsert(ctx, &memberModel{Email: "a@x", Name: "new"}, live); err != nil {
+		t.Fatal(err)
+	}
+
+	assertContains(t, log.last(), `ON CONFLICT ("email") WHERE deleted_at IS NULL DO UPDATE`)
+
+	var vs []memberModel
+
+	if err := db.NewSelect().Model(&vs).Order("id").Scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vs) != 2 || vs[0].Name != "old" || vs[1].Name != "new" {
+		t.Fatalf("unexpected rows: %+v", vs)
+	}
+
+	if err := m.With(ConflictTarget("email", "deleted_at IS NULL")).Save(ctx, &memberModel{Email: "a@x", Name: "saved"}); err != nil {
+		t.Fatal(err)
+	}
+
+	pdb, plog := testPostgres(t)
+	pm := testModels(t, pdb)
+
+	// SQLite cannot run the Postgres insert, only its SQL is checked
+	if err := pm.Upsert(ctx, &memberModel{Email: "b@x"}, live); err == nil {
+		t.Fatal("expected SQLite to reject the Postgres insert")
+	}
+
+	assertContains(t, plog.last(), `ON CONFLICT ("email") WHERE deleted_at IS NULL DO UPDATE`)
+
+	if err := pm.Upsert(ctx, &memberModel{}, ConflictTarget("nope", "")); err == nil {
+		t.Fatal("expected unknown column to fail")
+	}
+
+	mdb, _ := testMySQL(t)
+
+	if err := testModels(t, mdb).Upsert(ctx, &memberModel{}, live); err == nil {
+		t.Fatal("expected MySQL to fail")
+	}
+}